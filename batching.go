@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchConfig is the batching of an exporter that writes its results in the background.
+type batchConfig struct {
+	batchSize     int           // Write once this many results are pending
+	flushInterval time.Duration // Write the pending results at least this often
+	maxPending    int           // Drop the oldest results beyond this, 0 if the exporter doesn't buffer in memory
+}
+
+// batchConfigFromEnv overrides the defaults with <prefix>BATCH_SIZE, <prefix>FLUSH_INTERVAL and, if the
+// exporter has a limit, <prefix>MAX_PENDING.
+func batchConfigFromEnv(prefix string, defaults batchConfig) (batchConfig, error) {
+	config := defaults
+	var err error
+	if config.batchSize, err = positiveIntFromEnv(prefix+"BATCH_SIZE", defaults.batchSize); err != nil {
+		return batchConfig{}, err
+	}
+	if config.flushInterval, err = durationFromEnv(prefix+"FLUSH_INTERVAL", defaults.flushInterval); err != nil {
+		return batchConfig{}, err
+	}
+	if defaults.maxPending > 0 {
+		if config.maxPending, err = positiveIntFromEnv(prefix+"MAX_PENDING", defaults.maxPending); err != nil {
+			return batchConfig{}, err
+		}
+	}
+	return config, nil
+}
+
+func positiveIntFromEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+func durationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+// batchWriter calls the flush of an exporter in the background: right after it started, when woken because
+// a batch is full and every flush interval. While flush fails with an error worth retrying, it is retried
+// with backoff, other errors mean the results were dropped.
+type batchWriter struct {
+	sync.Mutex
+	name       string // Where the results are written to, for the logs
+	interval   time.Duration
+	flush      func(ctx context.Context) error
+	retry      func(err error) bool
+	newBackoff func() *backoff
+	wake       chan struct{}
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+func newBatchWriter(name string, interval time.Duration, flush func(ctx context.Context) error, retry func(err error) bool) *batchWriter {
+	return &batchWriter{
+		name:       name,
+		interval:   interval,
+		flush:      flush,
+		retry:      retry,
+		newBackoff: newBackoff,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// start starts writing, it returns false if the writer is already running.
+func (w *batchWriter) start() bool {
+	w.Lock()
+	defer w.Unlock()
+	if w.cancel != nil {
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go w.run(ctx, w.done)
+	return true
+}
+
+// stop stops writing and waits until the flush in progress returned, it returns false if the writer wasn't running.
+func (w *batchWriter) stop() bool {
+	w.Lock()
+	cancel, done := w.cancel, w.done
+	w.cancel = nil
+	w.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	<-done
+	return true
+}
+
+// notify wakes the writer, e.g. once a batch is full.
+func (w *batchWriter) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *batchWriter) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		err := w.flush(ctx)
+		wait := w.newBackoff()
+		for err != nil && w.retry(err) {
+			delay := wait.next()
+			Log.Warn("Failed to write results to ", w.name, ", retrying in ", delay, ": ", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			err = w.flush(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			Log.Error("Failed to write results to ", w.name, ", dropped them: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchConfigFromEnv(t *testing.T) {
+	defaults := batchConfig{batchSize: 500, flushInterval: 10 * time.Second, maxPending: 1000}
+	t.Setenv("TEST_BATCH_SIZE", "20")
+	t.Setenv("TEST_FLUSH_INTERVAL", "1s")
+	config, err := batchConfigFromEnv("TEST_", defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != (batchConfig{batchSize: 20, flushInterval: time.Second, maxPending: 1000}) {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for name, value := range map[string]string{"TEST_BATCH_SIZE": "0", "TEST_FLUSH_INTERVAL": "soon", "TEST_MAX_PENDING": "-1"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := batchConfigFromEnv("TEST_", defaults); err == nil {
+				t.Errorf("Expected an error for %s=%s", name, value)
+			}
+		})
+	}
+}
+
+func TestBatchWriterRetries(t *testing.T) {
+	errTransient := errors.New("unavailable")
+	var mu sync.Mutex
+	var flushes int
+	written := make(chan struct{})
+	writer := newBatchWriter("test", time.Hour, func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		flushes++
+		switch flushes {
+		case 1: // Right after the start
+			return nil
+		case 2, 3:
+			return errTransient
+		case 4:
+			close(written)
+		}
+		return nil
+	}, func(err error) bool { return errors.Is(err, errTransient) })
+	writer.newBackoff = func() *backoff { return &backoff{min: time.Millisecond, max: time.Millisecond} }
+
+	if !writer.start() || writer.start() {
+		t.Fatal("Expected the writer to start once")
+	}
+	writer.notify()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the retries")
+	}
+	if !writer.stop() || writer.stop() {
+		t.Error("Expected the writer to stop once")
+	}
+}
@@ -8,9 +8,6 @@ mkdir -p bin/v11
 CGO_ENABLED=1 CC="zig cc -target native-native-musl" CXX="zig cc -target native-native-musl" go build -ldflags "-X main.versionString=$(git describe --tags --dirty --always)"
 cp scion-go-multiping bin/v11/
 
+# The same binary connects dispatcher-less to v0.12+ hosts, see SCION_CONNECTION_MODE
 mkdir -p bin/v12
-git checkout scion-v12
-CGO_ENABLED=1 CC="zig cc -target native-native-musl" CXX="zig cc -target native-native-musl" go build -ldflags "-X main.versionString=$(git describe --tags --dirty --always)"
-cp scion-go-multiping bin/v12/
-
-git checkout master
\ No newline at end of file
+cp scion-go-multiping bin/v12/
\ No newline at end of file
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultCollectorListenAddress = ":8080"
+	defaultCollectorDbPath        = "collector.db"
+	collectorBatchPath            = "/api/v1/batches"
+	maxBatchBytes                 = 32 << 20
+)
+
+// resultBatch is what the PushExporter sends to the collector. The batch ID is assigned when the batch is
+// spooled and kept across retries, so the collector stores every batch once.
+type resultBatch struct {
+	BatchID            string              `json:"batch_id"`
+	Node               string              `json:"node"`
+	PingResults        []PingResult        `json:"ping_results,omitempty"`
+	PathStatistics     []PathStatistics    `json:"path_statistics,omitempty"`
+	IPPingResults      []IPPingResult      `json:"ip_ping_results,omitempty"`
+	OneWayDelayResults []OneWayDelayResult `json:"one_way_delay_results,omitempty"`
+}
+
+func (b *resultBatch) rows() int {
+	return len(b.PingResults) + len(b.PathStatistics) + len(b.IPPingResults) + len(b.OneWayDelayResults)
+}
+
+// CollectedBatch records every stored batch, to drop batches that are retried after they were stored.
+type CollectedBatch struct {
+	BatchID    string `gorm:"primaryKey"`
+	Node       string `gorm:"index"`
+	Rows       int
+	ReceivedAt time.Time
+}
+
+func (CollectedBatch) TableName() string { return "batches" }
+
+type Collector struct {
+	db    *gorm.DB
+	token string
+}
+
+func NewCollector(dbPath string, token string) (*Collector, error) {
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrateDb(db); err != nil {
+		closeDb(db)
+		return nil, err
+	}
+	if err := db.AutoMigrate(&CollectedBatch{}); err != nil {
+		closeDb(db)
+		return nil, err
+	}
+	// The results of all nodes are in the same tables, queried by node
+	for _, table := range resultTables {
+		if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_" + table + "_node_id ON " + table + " (node_id)").Error; err != nil {
+			closeDb(db)
+			return nil, err
+		}
+	}
+	// The rows are of many nodes, each with its own node ID, so the database has no node identity
+	if err := writeMetadata(db, NodeIdentity{}, ""); err != nil {
+		closeDb(db)
+		return nil, err
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer anyway
+	sqlDb.SetMaxOpenConns(1)
+
+	return &Collector{db: db, token: token}, nil
+}
+
+func (c *Collector) Close() error {
+	sqlDb, err := c.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Close()
+}
+
+// Store stores the batch and returns false if it was already stored before.
+func (c *Collector) Store(batch *resultBatch) (bool, error) {
+	if batch.BatchID == "" || batch.Node == "" {
+		return false, errors.New("batch without batch ID or node")
+	}
+
+	stored := false
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&CollectedBatch{
+			BatchID:    batch.BatchID,
+			Node:       batch.Node,
+			Rows:       batch.rows(),
+			ReceivedAt: time.Now().UTC(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		stored = true
+
+		// Results of nodes without node identity get the node the batch came from
+		if len(batch.PingResults) > 0 {
+			for i := range batch.PingResults {
+				if batch.PingResults[i].NodeID == "" {
+					batch.PingResults[i].NodeID = batch.Node
+				}
+			}
+			if err := tx.Create(&batch.PingResults).Error; err != nil {
+				return err
+			}
+		}
+		if len(batch.PathStatistics) > 0 {
+			for i := range batch.PathStatistics {
+				if batch.PathStatistics[i].NodeID == "" {
+					batch.PathStatistics[i].NodeID = batch.Node
+				}
+			}
+			if err := tx.Create(&batch.PathStatistics).Error; err != nil {
+				return err
+			}
+		}
+		if len(batch.IPPingResults) > 0 {
+			for i := range batch.IPPingResults {
+				if batch.IPPingResults[i].NodeID == "" {
+					batch.IPPingResults[i].NodeID = batch.Node
+				}
+			}
+			if err := tx.Create(&batch.IPPingResults).Error; err != nil {
+				return err
+			}
+		}
+		if len(batch.OneWayDelayResults) > 0 {
+			for i := range batch.OneWayDelayResults {
+				if batch.OneWayDelayResults[i].NodeID == "" {
+					batch.OneWayDelayResults[i].NodeID = batch.Node
+				}
+			}
+			if err := tx.Create(&batch.OneWayDelayResults).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return stored, err
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.token != "" && r.Header.Get("Authorization") != "Bearer "+c.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var batch resultBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("invalid batch: %s", err), http.StatusBadRequest)
+		return
+	}
+	if batch.BatchID == "" || batch.Node == "" {
+		http.Error(w, "batch_id and node are required", http.StatusBadRequest)
+		return
+	}
+
+	stored, err := c.Store(&batch)
+	if err != nil {
+		Log.Error("Failed to store batch ", batch.BatchID, " from ", batch.Node, ": ", err)
+		http.Error(w, "failed to store batch", http.StatusInternalServerError)
+		return
+	}
+
+	status := "stored"
+	if !stored {
+		status = "duplicate"
+		Log.Debug("Dropped duplicate batch ", batch.BatchID, " from ", batch.Node)
+	} else {
+		Log.Debug("Stored batch ", batch.BatchID, " with ", batch.rows(), " rows from ", batch.Node)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// runCollectorMode stores the results pushed by multiping nodes, see PushExporter.
+func runCollectorMode() {
+	listenAddress := os.Getenv("COLLECTOR_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		listenAddress = defaultCollectorListenAddress
+	}
+	dbPath := os.Getenv("COLLECTOR_DB_PATH")
+	if dbPath == "" {
+		dbPath = defaultCollectorDbPath
+	}
+
+	collector, err := NewCollector(dbPath, os.Getenv("COLLECTOR_TOKEN"))
+	if err != nil {
+		Log.Error("Failed to open collector database ", dbPath, ": ", err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(collectorBatchPath, collector)
+	server := &http.Server{
+		Addr:              listenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signalChannel
+		fmt.Printf("Received signal: %s\n", sig)
+		server.Close()
+	}()
+
+	Log.Info("Collecting results on ", listenAddress, " into ", dbPath)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		Log.Error("Collector failed: ", err)
+	}
+	if err := collector.Close(); err != nil {
+		Log.Error("Failed to close collector database ", err)
+	}
+	fmt.Println("Exiting...")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPushExporter_SpoolAndCollect(t *testing.T) {
+	dir := t.TempDir()
+	collector, err := NewCollector(filepath.Join(dir, "collector.db"), "secret")
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	defer collector.Close()
+
+	// The collector is unreachable for the first attempt
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		collector.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	t.Setenv("COLLECTOR_URL", server.URL)
+	t.Setenv("COLLECTOR_TOKEN", "secret")
+	t.Setenv("PUSH_SPOOL_DIR", filepath.Join(dir, "spool"))
+	t.Setenv("PUSH_BATCH_SIZE", "2")
+	exporter, err := NewPushExporter("node-1")
+	if err != nil {
+		t.Fatalf("Failed to create push exporter: %v", err)
+	}
+
+	exporter.WritePingResult(PingResult{SrcSCIONAddr: "1-ff00:0:110", DstSCIONAddr: "1-ff00:0:111", RTT: 12, PingTime: time.Now()})
+	exporter.WritePathStatistic(PathStatistics{SrcSCIONAddr: "1-ff00:0:110", DstSCIONAddr: "1-ff00:0:111"})
+	spooled, _ := exporter.spooled()
+	if len(spooled) != 1 {
+		t.Fatalf("Expected a full batch to be spooled, got %d", len(spooled))
+	}
+
+	if err := exporter.sendSpooled(context.Background()); err == nil {
+		t.Fatalf("Expected error while the collector is unavailable")
+	}
+	if spooled, _ := exporter.spooled(); len(spooled) != 1 {
+		t.Fatalf("Expected batch to stay spooled, got %d", len(spooled))
+	}
+	if err := exporter.sendSpooled(context.Background()); err != nil {
+		t.Fatalf("Failed to send spooled batch: %v", err)
+	}
+	if spooled, _ := exporter.spooled(); len(spooled) != 0 {
+		t.Errorf("Expected sent batch to be removed from the spool, got %d", len(spooled))
+	}
+
+	var count int64
+	collector.db.Model(&PingResult{}).Where("node_id = ?", "node-1").Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 collected ping result of node-1, got %d", count)
+	}
+}
+
+func TestCollector_DuplicateBatch(t *testing.T) {
+	collector, err := NewCollector(filepath.Join(t.TempDir(), "collector.db"), "")
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	defer collector.Close()
+
+	batch := &resultBatch{
+		BatchID:     "b1",
+		Node:        "node-1",
+		PingResults: []PingResult{{SrcSCIONAddr: "1-ff00:0:110", DstSCIONAddr: "1-ff00:0:111"}},
+	}
+	for i, expected := range []bool{true, false} {
+		stored, err := collector.Store(batch)
+		if err != nil {
+			t.Fatalf("Failed to store batch: %v", err)
+		}
+		if stored != expected {
+			t.Errorf("Attempt %d: expected stored %v, got %v", i, expected, stored)
+		}
+	}
+
+	var count int64
+	collector.db.Model(&PingResult{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected retried batch to be stored once, got %d rows", count)
+	}
+	if version, err := schemaVersion(collector.db, "main"); err != nil || version != currentSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d: %v", currentSchemaVersion, version, err)
+	}
+}
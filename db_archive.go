@@ -0,0 +1,301 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// dbArchiver processes rotated databases: optionally VACUUM, compress and move them to an archive directory,
+// and remove the oldest ones beyond the retention limits.
+type dbArchiver struct {
+	sync.Mutex
+	vacuum         bool
+	compression    string
+	archiveDir     string        // Empty to keep them next to the live database
+	parquetDir     string        // Empty to not export them to Parquet
+	retentionAge   time.Duration // 0 to keep them forever
+	retentionBytes int64         // 0 for no size limit
+}
+
+// newDbArchiverFromEnv is configured with EXPORTER_SQLITE_VACUUM, EXPORTER_SQLITE_COMPRESSION (gzip or zstd),
+// EXPORTER_SQLITE_ARCHIVE_DIR, EXPORTER_SQLITE_PARQUET_DIR, EXPORTER_SQLITE_RETENTION_DAYS and
+// EXPORTER_SQLITE_RETENTION_MAX_MB.
+func newDbArchiverFromEnv() (*dbArchiver, error) {
+	archiver := &dbArchiver{
+		vacuum:      os.Getenv("EXPORTER_SQLITE_VACUUM") == "true",
+		compression: os.Getenv("EXPORTER_SQLITE_COMPRESSION"),
+		archiveDir:  os.Getenv("EXPORTER_SQLITE_ARCHIVE_DIR"),
+		parquetDir:  os.Getenv("EXPORTER_SQLITE_PARQUET_DIR"),
+	}
+	switch archiver.compression {
+	case compressionNone, compressionGzip, compressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown EXPORTER_SQLITE_COMPRESSION %q, expected gzip or zstd", archiver.compression)
+	}
+	if days := os.Getenv("EXPORTER_SQLITE_RETENTION_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid EXPORTER_SQLITE_RETENTION_DAYS %q", days)
+		}
+		archiver.retentionAge = time.Duration(n) * 24 * time.Hour
+	}
+	if mb := os.Getenv("EXPORTER_SQLITE_RETENTION_MAX_MB"); mb != "" {
+		n, err := strconv.ParseInt(mb, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid EXPORTER_SQLITE_RETENTION_MAX_MB %q", mb)
+		}
+		archiver.retentionBytes = n << 20
+	}
+	return archiver, nil
+}
+
+// process archives the rotated databases matching the pattern, except the open ones, and enforces the retention.
+func (a *dbArchiver) process(pattern string, open []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	rotated, err := filepath.Glob(pattern)
+	if err != nil {
+		Log.Error("Failed to list rotated databases: ", err)
+		return
+	}
+	root := globRoot(pattern)
+	for _, path := range rotated {
+		if containsString(open, path) {
+			continue
+		}
+		if err := a.archive(path, root); err != nil {
+			// Keep it as it is, we try again after the next rotation
+			Log.Error("Failed to archive database ", path, ": ", err)
+		}
+	}
+
+	archivePattern := pattern
+	if a.archiveDir != "" {
+		rel, _ := filepath.Rel(root, pattern)
+		archivePattern = filepath.Join(a.archiveDir, rel)
+	}
+	if err := a.enforceRetention(archivePattern+"*", open); err != nil {
+		Log.Error("Failed to enforce retention: ", err)
+	}
+}
+
+// globRoot returns the directory of the pattern without wildcards, e.g. /data for /data/*/pingmetrics_*.db.
+func globRoot(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return filepath.Dir(pattern + "x")
+}
+
+// archive archives the database, below the archive directory it keeps its path relative to root, so
+// databases of periods with the same file name in different directories don't overwrite each other.
+func (a *dbArchiver) archive(path string, root string) error {
+	if a.vacuum {
+		if err := vacuumDb(path); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(path + "-wal"); err == nil {
+		// Not closed cleanly, e.g. after a crash, move the WAL into the database before archiving it
+		if err := execDb(path, "PRAGMA wal_checkpoint("+checkpointTruncate+")"); err != nil {
+			return err
+		}
+	}
+	if a.parquetDir != "" && !parquetExported(path, a.parquetDir) {
+		files, err := exportParquet(path, a.parquetDir)
+		if err != nil {
+			return err
+		}
+		Log.Info("Exported database ", path, " to ", len(files), " Parquet files in ", a.parquetDir)
+	}
+
+	target := path
+	if a.archiveDir != "" {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target = filepath.Join(a.archiveDir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+	}
+
+	switch a.compression {
+	case compressionGzip:
+		target += ".gz"
+	case compressionZstd:
+		target += ".zst"
+	}
+	if target == path {
+		return nil
+	}
+	if _, err := os.Stat(target); err == nil {
+		// E.g. the database was reopened for late results after it was archived, don't lose the archive
+		return fmt.Errorf("archive %s already exists", target)
+	}
+
+	if a.compression == compressionNone {
+		if err := os.Rename(path, target); err == nil {
+			Log.Info("Archived database ", path, " to ", target)
+			return nil
+		}
+		// Probably on another file system, copy it instead
+	}
+	if err := compressFile(path, target, a.compression); err != nil {
+		return err
+	}
+	Log.Info("Archived database ", path, " to ", target)
+	return os.Remove(path)
+}
+
+// isOpenDb returns whether the path is one of the open databases or one of their journal files.
+func isOpenDb(path string, open []string) bool {
+	for _, o := range open {
+		if strings.HasPrefix(path, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// vacuumDb runs VACUUM if the database has free pages. Databases kept in place are processed again after
+// every rotation, they are only rewritten once, so they keep their modification time e.g. for the Parquet export.
+func vacuumDb(path string) error {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	defer closeDb(db)
+	var free int64
+	if err := db.Raw("PRAGMA freelist_count").Scan(&free).Error; err != nil || free == 0 {
+		return err
+	}
+	return db.Exec("VACUUM").Error
+}
+
+func execDb(path string, statement string) error {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	defer closeDb(db)
+	return db.Exec(statement).Error
+}
+
+// compressFile writes the compressed file to target, or a copy without compression.
+func compressFile(path string, target string, compression string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := target + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	var w io.WriteCloser
+	switch compression {
+	case compressionGzip:
+		w = gzip.NewWriter(out)
+	case compressionZstd:
+		w, err = zstd.NewWriter(out)
+		if err != nil {
+			return err
+		}
+	default:
+		w = out
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	return os.Rename(tmp, target)
+}
+
+// enforceRetention removes the archived databases that are older than the retention age, and the oldest
+// ones while they take more space than allowed.
+func (a *dbArchiver) enforceRetention(pattern string, open []string) error {
+	if a.retentionAge == 0 && a.retentionBytes == 0 {
+		return nil
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	type archived struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []archived
+	var total int64
+	for _, path := range paths {
+		if isOpenDb(path, open) || strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, archived{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, file := range files {
+		tooOld := a.retentionAge > 0 && time.Since(file.modTime) > a.retentionAge
+		tooBig := a.retentionBytes > 0 && total > a.retentionBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil {
+			return err
+		}
+		total -= file.size
+		if tooOld {
+			Log.Info("Removed database ", file.path, " older than ", a.retentionAge)
+		} else {
+			Log.Info("Removed database ", file.path, " to stay below ", a.retentionBytes>>20, "MB")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDbArchiverCompressAndMove(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	old := filepath.Join(dir, "pingmetrics_2024-01-01.db")
+	live := filepath.Join(dir, "pingmetrics_2024-01-02.db")
+	for _, path := range []string{old, live} {
+		if err := os.WriteFile(path, []byte("sqlite"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archiver := &dbArchiver{compression: compressionGzip, archiveDir: archiveDir}
+	archiver.process(filepath.Join(dir, "pingmetrics_*.db"), []string{live})
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed after archiving", old)
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Errorf("Expected the live database to be kept: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(archiveDir, "pingmetrics_2024-01-01.db.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "sqlite" {
+		t.Errorf("Unexpected archived content %q: %v", data, err)
+	}
+}
+
+func TestDbArchiverKeepsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	// Same file name in the directories of different days
+	for _, day := range []string{"2024-01-01", "2024-01-02"} {
+		if err := os.MkdirAll(filepath.Join(dir, day), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, day, "10.db"), []byte(day), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archiver := &dbArchiver{archiveDir: archiveDir}
+	archiver.process(filepath.Join(dir, "*", "*.db"), nil)
+
+	for _, day := range []string{"2024-01-01", "2024-01-02"} {
+		data, err := os.ReadFile(filepath.Join(archiveDir, day, "10.db"))
+		if err != nil || string(data) != day {
+			t.Errorf("Expected the database of %s to be archived, got %q: %v", day, data, err)
+		}
+	}
+}
+
+func TestDbArchiverKeepsExistingArchive(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	path := filepath.Join(dir, "pingmetrics_2024-01-01.db")
+	archived := filepath.Join(archiveDir, "pingmetrics_2024-01-01.db")
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archived, []byte("archived"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("late"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{compressionNone, compressionGzip} {
+		archiver := &dbArchiver{compression: compression, archiveDir: archiveDir}
+		if compression == compressionGzip {
+			if err := os.WriteFile(archived+".gz", []byte("archived"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := archiver.archive(path, dir); err == nil {
+			t.Errorf("Expected an error archiving over an existing archive with compression %q", compression)
+		}
+	}
+	if data, err := os.ReadFile(archived); err != nil || string(data) != "archived" {
+		t.Errorf("Expected the archive to be kept, got %q: %v", data, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the database to be kept: %v", err)
+	}
+}
+
+func TestDbArchiverRetention(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "pingmetrics_2024-01-10.db")
+	now := time.Now()
+	files := map[string]time.Duration{
+		"pingmetrics_2024-01-01.db.zst": 9 * 24 * time.Hour,
+		"pingmetrics_2024-01-08.db.zst": 2 * 24 * time.Hour,
+		"pingmetrics_2024-01-09.db.zst": 1 * 24 * time.Hour,
+		"pingmetrics_2024-01-10.db":     0,
+	}
+	for name, age := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 1<<20), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archiver := &dbArchiver{retentionAge: 7 * 24 * time.Hour, retentionBytes: 1 << 20}
+	if err := archiver.enforceRetention(filepath.Join(dir, "pingmetrics_*.db*"), []string{live}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, kept := range map[string]bool{
+		"pingmetrics_2024-01-01.db.zst": false, // Too old
+		"pingmetrics_2024-01-08.db.zst": false, // Oldest beyond the size limit
+		"pingmetrics_2024-01-09.db.zst": true,
+		"pingmetrics_2024-01-10.db":     true, // Live
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if kept != (err == nil) {
+			t.Errorf("Expected %s kept=%v, got error %v", name, kept, err)
+		}
+	}
+}
+
+func TestDbArchiverProcessesDatabasesInPlaceOnce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pingmetrics_2024-01-01.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Free pages for VACUUM to reclaim
+	db.Exec("CREATE TABLE results (data text)")
+	for i := 0; i < 100; i++ {
+		db.Exec("INSERT INTO results VALUES (?)", string(make([]byte, 1000)))
+	}
+	db.Exec("DELETE FROM results")
+	closeDb(db)
+
+	archiver := &dbArchiver{vacuum: true}
+	archiver.process(filepath.Join(dir, "pingmetrics_*.db"), nil)
+	vacuumed, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The modification time has a coarse resolution on some file systems
+	time.Sleep(10 * time.Millisecond)
+	archiver.process(filepath.Join(dir, "pingmetrics_*.db"), nil)
+	processed, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !processed.ModTime().Equal(vacuumed.ModTime()) {
+		t.Errorf("Expected the database not to be rewritten when processed again")
+	}
+	if vacuumed.Size() > 8192 {
+		t.Errorf("Expected the database to be vacuumed, got %d bytes", vacuumed.Size())
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Key of the rows of each result table in merged databases, rows with the key of a row already merged are
+// dropped. Rows of older versions have no node ID and no probe type, which is set to SCMP after merging.
+var mergeKeys = map[string]string{
+	"ping_results":          "IFNULL(node_id, ''), ping_time, src_scion_addr, dst_scion_addr, " + probeTypeKey,
+	"path_statistics":       "IFNULL(node_id, ''), lookup_time, src_scion_addr, dst_scion_addr, " + probeTypeKey,
+	"ip_ping_results":       "IFNULL(node_id, ''), ping_time, src_addr, dst_addr",
+	"one_way_delay_results": "IFNULL(node_id, ''), probe_time, src_scion_addr, dst_scion_addr, fingerprint",
+}
+
+const probeTypeKey = "IFNULL(NULLIF(probe_type, ''), '" + probeTypeSCMP + "')"
+
+// Date in the name of daily or hourly databases, e.g. pingmetrics_2024-05-01.db or pingmetrics_2024-05-01T13.db
+var dailyDbDate = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})(T\d{2})?\.db$`)
+
+// runDBCommand runs `db merge`, `db query`, `db migrate` and `db parquet`, returning the exit code.
+func runDBCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: scion-go-multiping db merge|query|migrate|parquet [flags] files...")
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "merge":
+		err = runDBMerge(args[1:])
+	case "query":
+		err = runDBQuery(args[1:])
+	case "migrate":
+		err = runDBMigrate(args[1:])
+	case "parquet":
+		err = runDBParquet(args[1:])
+	default:
+		err = fmt.Errorf("unknown db command %q, expected merge, query, migrate or parquet", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func runDBMerge(args []string) error {
+	flags := flag.NewFlagSet("db merge", flag.ContinueOnError)
+	output := flags.String("o", "merged.db", "database to merge into, created if it does not exist")
+	node := flags.String("node", "", "node ID for rows of files without one, e.g. files of older versions")
+	timezone := flags.String("timezone", "UTC", "time zone the hours of the rollups start in, as EXPORTER_SQLITE_TIMEZONE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return err
+	}
+	files, err := expandDbFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no databases to merge")
+	}
+
+	db, err := openMergeDb(*output)
+	if err != nil {
+		return err
+	}
+	defer closeDb(db)
+
+	for _, file := range files {
+		rows, err := mergeDb(db, file, *node)
+		if err != nil {
+			return fmt.Errorf("merging %s: %w", file, err)
+		}
+		fmt.Printf("Merged %d new rows from %s\n", rows, file)
+	}
+	// Rows of files before the probe type was recorded
+	if err := backfillProbeTypes(db); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := mergeRollups(db, file, location); err != nil {
+			return fmt.Errorf("merging rollups of %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// runDBMigrate upgrades the databases in place to the current schema.
+func runDBMigrate(args []string) error {
+	flags := flag.NewFlagSet("db migrate", flag.ContinueOnError)
+	node := flags.String("node", "", "node ID for rows without one, e.g. of files of older versions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	files, err := expandDbFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no databases to migrate")
+	}
+
+	for _, file := range files {
+		from, to, err := migrateDbFile(file, *node)
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", file, err)
+		}
+		fmt.Printf("Migrated %s from schema version %d to %d\n", file, from, to)
+	}
+	return nil
+}
+
+func migrateDbFile(file string, node string) (int, int, error) {
+	db, err := gorm.Open(sqlite.Open(file), &gorm.Config{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closeDb(db)
+
+	from, err := schemaVersion(db, "main")
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := migrateDb(db)
+	if err != nil {
+		return from, to, err
+	}
+	if node != "" {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return setNodeID(tx, node)
+		})
+	}
+	return from, to, err
+}
+
+// expandDbFiles returns the databases in the given files, globs and directories, sorted by name.
+func expandDbFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			arg = filepath.Join(arg, "*.db")
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no database found for %s", arg)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// openMergeDb opens the database with the current schema, which older files are reconciled with.
+func openMergeDb(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrateDb(db); err != nil {
+		return nil, err
+	}
+	if err := indexMergeKeys(db); err != nil {
+		return nil, err
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// Attached databases are per connection
+	sqlDb.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// indexMergeKeys creates the unique indexes duplicates are dropped by when merging. Databases merged by
+// older versions can have duplicates, the first row is kept.
+func indexMergeKeys(db *gorm.DB) error {
+	for _, table := range resultTables {
+		index := "idx_" + table + "_merge_key"
+		var exists int64
+		if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?", index).Scan(&exists).Error; err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid NOT IN (SELECT MIN(rowid) FROM %s GROUP BY %s)",
+				table, table, mergeKeys[table])).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", index, table, mergeKeys[table])).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func closeDb(db *gorm.DB) {
+	sqlDb, err := db.DB()
+	if err == nil {
+		sqlDb.Close()
+	}
+}
+
+// mergeDb copies the rows of all result tables of the file that are not in db yet. Columns the file
+// doesn't have are left empty, columns db doesn't have are dropped.
+func mergeDb(db *gorm.DB, file string, node string) (int64, error) {
+	if err := attachSource(db, file); err != nil {
+		return 0, err
+	}
+	defer db.Exec("DETACH DATABASE src")
+
+	version, err := schemaVersion(db, "src")
+	if err != nil {
+		return 0, err
+	}
+	if version > currentSchemaVersion {
+		return 0, fmt.Errorf("schema version %d is newer than %d of this version, upgrade multiping", version, currentSchemaVersion)
+	}
+
+	var merged int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range resultTables {
+			srcColumns, err := tableColumns(tx, "src", table)
+			if err != nil {
+				return err
+			}
+			if len(srcColumns) == 0 {
+				continue
+			}
+			dstColumns, err := tableColumns(tx, "main", table)
+			if err != nil {
+				return err
+			}
+
+			var columns, selects []string
+			for _, column := range dstColumns {
+				if containsString(srcColumns, column) {
+					columns = append(columns, quoteIdent(column))
+					selects = append(selects, quoteIdent(column))
+				} else if column == "node_id" && node != "" {
+					columns = append(columns, quoteIdent(column))
+					selects = append(selects, "'"+strings.ReplaceAll(node, "'", "''")+"'")
+				}
+			}
+			if len(columns) == 0 {
+				continue
+			}
+
+			// The unique index on the key drops duplicates within the file and rows that were merged before
+			result := tx.Exec(fmt.Sprintf("INSERT OR IGNORE INTO main.%s (%s) SELECT %s FROM src.%s",
+				table, strings.Join(columns, ", "), strings.Join(selects, ", "), table))
+			if result.Error != nil {
+				return result.Error
+			}
+			merged += result.RowsAffected
+		}
+		return nil
+	})
+	return merged, err
+}
+
+// mergeRollups rebuilds the rollups of the hours of the ping results in the file from the merged ping results.
+// Adding up the rollups of the files instead would count results twice when a file is merged again.
+func mergeRollups(db *gorm.DB, file string, location *time.Location) error {
+	if err := attachSource(db, file); err != nil {
+		return err
+	}
+	defer db.Exec("DETACH DATABASE src")
+
+	columns, err := tableColumns(db, "src", "ping_results")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	var from, to sql.NullInt64
+	err = db.Raw("SELECT CAST(strftime('%s', MIN(ping_time)) AS INTEGER), CAST(strftime('%s', MAX(ping_time)) AS INTEGER) FROM src.ping_results").
+		Row().Scan(&from, &to)
+	if err != nil || !from.Valid || !to.Valid {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return rebuildRollups(tx, time.Unix(from.Int64, 0), time.Unix(to.Int64, 0), location)
+	})
+}
+
+// attachSource attaches the database to merge from as src, read-only so it can be the live database of the exporter.
+func attachSource(db *gorm.DB, file string) error {
+	return db.Exec("ATTACH DATABASE ? AS src", readOnlyURI(file)).Error
+}
+
+// tableColumns returns the columns of the table in order, none if it doesn't exist.
+func tableColumns(db *gorm.DB, schema string, table string) ([]string, error) {
+	rows, err := db.Raw("SELECT name FROM pragma_table_info(?, ?) ORDER BY cid", table, schema).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func runDBQuery(args []string) error {
+	flags := flag.NewFlagSet("db query", flag.ContinueOnError)
+	report := flags.String("report", "availability", "availability, rtt or paths")
+	from := flags.String("from", "", "first day to include, YYYY-MM-DD (default: all)")
+	to := flags.String("to", "", "last day to include, YYYY-MM-DD (default: all)")
+	interval := flags.String("interval", "day", "interval of the availability and paths reports, hour or day")
+	format := flags.String("format", "table", "table or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rangeFrom, rangeTo, err := parseDateRange(*from, *to)
+	if err != nil {
+		return err
+	}
+	var bucket string
+	switch *interval {
+	case "hour":
+		bucket = "%Y-%m-%d %H:00"
+	case "day":
+		bucket = "%Y-%m-%d"
+	default:
+		return fmt.Errorf("unknown interval %q, expected hour or day", *interval)
+	}
+
+	files, err := expandDbFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	files = filterDailyDbs(files, rangeFrom, rangeTo)
+	if len(files) == 0 {
+		return errors.New("no databases in the date range")
+	}
+
+	// Merge into a temporary database, so the aggregations span all files
+	tmp, err := os.CreateTemp("", "multiping-query-*.db")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	db, err := openMergeDb(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer closeDb(db)
+	for _, file := range files {
+		if _, err := mergeDb(db, file, ""); err != nil {
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+	}
+
+	var header []string
+	var rows [][]string
+	switch *report {
+	case "availability":
+		header, rows, err = queryAvailability(db, bucket, rangeFrom, rangeTo)
+	case "rtt":
+		header, rows, err = queryRTTPercentiles(db, rangeFrom, rangeTo)
+	case "paths":
+		header, rows, err = queryPathCounts(db, bucket, rangeFrom, rangeTo)
+	default:
+		err = fmt.Errorf("unknown report %q, expected availability, rtt or paths", *report)
+	}
+	if err != nil {
+		return err
+	}
+	return writeReport(os.Stdout, *format, header, rows)
+}
+
+// parseDateRange returns the range [from, to) in UTC, open ends are the zero time.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	var rangeFrom, rangeTo time.Time
+	var err error
+	if from != "" {
+		if rangeFrom, err = time.Parse("2006-01-02", from); err != nil {
+			return rangeFrom, rangeTo, fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if to != "" {
+		if rangeTo, err = time.Parse("2006-01-02", to); err != nil {
+			return rangeFrom, rangeTo, fmt.Errorf("invalid -to: %w", err)
+		}
+		rangeTo = rangeTo.AddDate(0, 0, 1)
+	}
+	return rangeFrom, rangeTo, nil
+}
+
+// filterDailyDbs skips daily databases outside the range, files without a date in the name are kept.
+func filterDailyDbs(files []string, from, to time.Time) []string {
+	var filtered []string
+	for _, file := range files {
+		match := dailyDbDate.FindStringSubmatch(filepath.Base(file))
+		if match != nil {
+			day, err := time.Parse("2006-01-02", match[1])
+			if err == nil && ((!from.IsZero() && day.Before(from)) || (!to.IsZero() && !day.Before(to))) {
+				continue
+			}
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
+
+// timeFilter returns the condition and arguments to restrict the time column to the range.
+func timeFilter(column string, from, to time.Time) (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if !from.IsZero() {
+		conditions = append(conditions, column+" >= ?")
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		conditions = append(conditions, column+" < ?")
+		args = append(args, to)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func queryAvailability(db *gorm.DB, bucket string, from, to time.Time) ([]string, [][]string, error) {
+	where, args := timeFilter("ping_time", from, to)
+	rows, err := db.Raw(fmt.Sprintf(`SELECT strftime('%s', ping_time) AS bucket, COALESCE(node_id, ''), dst_scion_addr, COUNT(*),
+		SUM(CASE WHEN success THEN 1 ELSE 0 END), SUM(CASE WHEN local_stack_down THEN 1 ELSE 0 END)
+		FROM ping_results WHERE %s AND (probe_type IS NULL OR probe_type IN ('', 'scmp'))
+		GROUP BY 1, 2, 3 ORDER BY 1, 2, 3`, bucket, where), args...).Rows()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	header := []string{"interval", "node", "destination", "pings", "successful", "availability_%"}
+	var report [][]string
+	for rows.Next() {
+		var interval, node, dst string
+		var count, successes, localStackDown int64
+		if err := rows.Scan(&interval, &node, &dst, &count, &successes, &localStackDown); err != nil {
+			return nil, nil, err
+		}
+		// Pings that weren't sent because of the local stack don't tell anything about the destination
+		availability := "-"
+		if count > localStackDown {
+			availability = strconv.FormatFloat(100*float64(successes)/float64(count-localStackDown), 'f', 2, 64)
+		}
+		report = append(report, []string{interval, node, dst, strconv.FormatInt(count, 10), strconv.FormatInt(successes, 10), availability})
+	}
+	return header, report, rows.Err()
+}
+
+func queryRTTPercentiles(db *gorm.DB, from, to time.Time) ([]string, [][]string, error) {
+	where, args := timeFilter("ping_time", from, to)
+	rows, err := db.Raw(fmt.Sprintf(`SELECT COALESCE(node_id, ''), dst_scion_addr, COALESCE(probe_type, ''), rtt
+		FROM ping_results WHERE %s AND success ORDER BY 1, 2, 3, 4`, where), args...).Rows()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	header := []string{"node", "destination", "probe_type", "count", "min_ms", "p50_ms", "p95_ms", "p99_ms", "max_ms"}
+	var report [][]string
+	var key [3]string
+	var rtts []float64
+	flush := func() {
+		if len(rtts) == 0 {
+			return
+		}
+		report = append(report, []string{key[0], key[1], key[2], strconv.Itoa(len(rtts)),
+			formatMs(rtts[0]), formatMs(percentile(rtts, 50)), formatMs(percentile(rtts, 95)),
+			formatMs(percentile(rtts, 99)), formatMs(rtts[len(rtts)-1])})
+		rtts = rtts[:0]
+	}
+	for rows.Next() {
+		var next [3]string
+		var rtt float64
+		if err := rows.Scan(&next[0], &next[1], &next[2], &rtt); err != nil {
+			return nil, nil, err
+		}
+		if next != key {
+			flush()
+			key = next
+		}
+		rtts = append(rtts, rtt)
+	}
+	flush()
+	return header, report, rows.Err()
+}
+
+func queryPathCounts(db *gorm.DB, bucket string, from, to time.Time) ([]string, [][]string, error) {
+	where, args := timeFilter("lookup_time", from, to)
+	rows, err := db.Raw(fmt.Sprintf(`SELECT strftime('%s', lookup_time) AS bucket, COALESCE(node_id, ''), dst_scion_addr,
+		AVG(available_paths), MAX(available_paths), AVG(active_paths), MIN(active_paths)
+		FROM path_statistics WHERE %s AND NOT COALESCE(local_stack_down, 0)
+		GROUP BY 1, 2, 3 ORDER BY 1, 2, 3`, bucket, where), args...).Rows()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	header := []string{"interval", "node", "destination", "avg_available", "max_available", "avg_active", "min_active"}
+	var report [][]string
+	for rows.Next() {
+		var interval, node, dst string
+		var avgAvailable, avgActive float64
+		var maxAvailable, minActive int64
+		if err := rows.Scan(&interval, &node, &dst, &avgAvailable, &maxAvailable, &avgActive, &minActive); err != nil {
+			return nil, nil, err
+		}
+		report = append(report, []string{interval, node, dst,
+			strconv.FormatFloat(avgAvailable, 'f', 1, 64), strconv.FormatInt(maxAvailable, 10),
+			strconv.FormatFloat(avgActive, 'f', 1, 64), strconv.FormatInt(minActive, 10)})
+	}
+	return header, report, rows.Err()
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 1, 64)
+}
+
+func writeReport(w io.Writer, format string, header []string, rows [][]string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table or csv", format)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testOldDailyDb creates a daily database of an older version, without probe types and node identity.
+func testOldDailyDb(t *testing.T, path string, pingTime time.Time) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer closeDb(db)
+	err = db.Exec(`CREATE TABLE ping_results (src_scion_addr text, dst_scion_addr text, success numeric, rtt real,
+		fingerprint text, ping_time datetime, successful_pings integer, max_pings integer)`).Error
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for i, success := range []bool{true, false} {
+		err = db.Exec("INSERT INTO ping_results VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			"1-ff00:0:110,10.0.0.1", "1-ff00:0:111,10.0.0.2", success, 12.5, "fp", pingTime.Add(time.Duration(i)*time.Minute), 1, 1).Error
+		if err != nil {
+			t.Fatalf("Failed to insert ping result: %v", err)
+		}
+	}
+}
+
+func TestDBMerge_DeduplicatesAndReconcilesSchema(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testOldDailyDb(t, filepath.Join(dir, "pingmetrics_2024-05-01.db"), day)
+
+	db, err := openMergeDb(filepath.Join(dir, "merged.db"))
+	if err != nil {
+		t.Fatalf("Failed to open merge database: %v", err)
+	}
+	defer closeDb(db)
+
+	// Merging the same file twice must not duplicate rows
+	for i, expected := range []int64{2, 0} {
+		rows, err := mergeDb(db, filepath.Join(dir, "pingmetrics_2024-05-01.db"), "node-1")
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		if rows != expected {
+			t.Errorf("Merge %d: expected %d new rows, got %d", i, expected, rows)
+		}
+	}
+
+	var count int64
+	db.Model(&PingResult{}).Where("node_id = ?", "node-1").Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 rows labeled with node-1, got %d", count)
+	}
+
+	header, rows, err := queryAvailability(db, "%Y-%m-%d", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Failed to query availability: %v", err)
+	}
+	var out bytes.Buffer
+	writeReport(&out, "csv", header, rows)
+	if !strings.Contains(out.String(), "2024-05-01,node-1,\"1-ff00:0:111,10.0.0.2\",2,1,50.00") {
+		t.Errorf("Unexpected availability report:\n%s", out.String())
+	}
+}
+
+func TestDBMerge_DeduplicatesRowsWithoutNodeAndProbeType(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pingmetrics_2024-05-01.db")
+	testOldDailyDb(t, file, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	db, err := openMergeDb(filepath.Join(dir, "merged.db"))
+	if err != nil {
+		t.Fatalf("Failed to open merge database: %v", err)
+	}
+	defer closeDb(db)
+
+	// The probe type of the merged rows is set after the first merge, the rows of the second are still without
+	for i, expected := range []int64{2, 0} {
+		rows, err := mergeDb(db, file, "")
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		if rows != expected {
+			t.Errorf("Merge %d: expected %d new rows, got %d", i, expected, rows)
+		}
+		if err := backfillProbeTypes(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDBMerge_Rollups(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pingmetrics_2024-05-01.db")
+	db, err := openMergeDb(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var results []PingResult
+	for i := 0; i < 90; i++ {
+		results = append(results, PingResult{DstSCIONAddr: "1-ff00:0:111", ProbeType: probeTypeSCMP, NodeID: "node-1",
+			PingTime: start.Add(time.Duration(i) * time.Minute), Success: true, RTT: float64(i), Fingerprint: "a"})
+	}
+	if err := db.Create(&results).Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDb(db)
+
+	// Merging again must not count the results twice
+	merged := filepath.Join(dir, "merged.db")
+	for i := 0; i < 2; i++ {
+		if err := runDBMerge([]string{"-o", merged, file}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err = openMergeDb(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDb(db)
+	var hours []PingRollupHour
+	if err := db.Where("fingerprint = ''").Order("bucket_start").Find(&hours).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 2 || hours[0].Count != 60 || hours[1].Count != 30 || hours[1].MinRTT != 60 {
+		t.Errorf("Unexpected hour rollups %+v", hours)
+	}
+	var minutes int64
+	db.Model(&PingRollupMinute{}).Where("fingerprint = 'a'").Count(&minutes)
+	if minutes != 90 {
+		t.Errorf("Expected 90 minute rollups of the path, got %d", minutes)
+	}
+}
+
+func TestFilterDailyDbs(t *testing.T) {
+	from, to, _ := parseDateRange("2024-05-02", "2024-05-03")
+	files := filterDailyDbs([]string{"pingmetrics_2024-05-01.db", "pingmetrics_2024-05-02.db",
+		"pingmetrics_2024-05-03.db", "pingmetrics_2024-05-04.db", "merged.db"}, from, to)
+	if strings.Join(files, " ") != "pingmetrics_2024-05-02.db pingmetrics_2024-05-03.db merged.db" {
+		t.Errorf("Unexpected files in range: %v", files)
+	}
+}
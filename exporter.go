@@ -1,48 +1,207 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 )
 
 type PingResult struct {
-	SrcSCIONAddr    string    // SCION src
-	DstSCIONAddr    string    // SCION dst
-	Success         bool      // SuccessfulPings > 0
-	RTT             float64   // min rtt across path probed
-	Fingerprint     string    // Fingerprint of the path with the min rtt
-	PingTime        time.Time // time ping result was stored
-	SuccessfulPings int       // Ping replies count
-	MaxPings        int       // Sent ping count
+	SrcSCIONAddr    string    `parquet:"src_scion_addr,dict"`                  // SCION src
+	DstSCIONAddr    string    `parquet:"dst_scion_addr,dict"`                  // SCION dst
+	Success         bool      `parquet:"success"`                              // SuccessfulPings > 0
+	RTT             float64   `parquet:"rtt"`                                  // min rtt across path probed
+	Fingerprint     string    `parquet:"fingerprint,dict"`                     // Fingerprint of the path with the min rtt
+	PingTime        time.Time `parquet:"ping_time,timestamp(microsecond:utc)"` // time ping result was stored
+	SuccessfulPings int       `parquet:"successful_pings"`                     // Ping replies count
+	MaxPings        int       `parquet:"max_pings"`                            // Sent ping count
+	LocalStackDown  bool      `parquet:"local_stack_down"`                     // Not pinged, the local SCION stack was unavailable
+	ProbeType       string    `parquet:"probe_type,dict"`                      // scmp, udp or owd
+	MeshID          string    `parquet:"mesh_id,dict"`                         // Mesh the result belongs to, empty if not in mesh mode
+	NodeID          string    `parquet:"node_id,dict"`                         // Configured node ID, see NodeIdentity
+	NodeSite        string    `parquet:"node_site,dict"`                       // Configured site name
+	NodeTags        string    `parquet:"node_tags,dict"`                       // Configured tags, e.g. provider=geant,location=paris
 }
 
 type IPPingResult struct {
-	SrcAddr  string
-	DstAddr  string
-	Success  bool      // SuccessfulPings > 0
-	RTT      float64   // min rtt across path probed
-	PingTime time.Time // time ping result was stored
+	SrcAddr  string    `parquet:"src_addr,dict"`
+	DstAddr  string    `parquet:"dst_addr,dict"`
+	Success  bool      `parquet:"success"`                              // SuccessfulPings > 0
+	RTT      float64   `parquet:"rtt"`                                  // min rtt across path probed
+	PingTime time.Time `parquet:"ping_time,timestamp(microsecond:utc)"` // time ping result was stored
+	MeshID   string    `parquet:"mesh_id,dict"`                         // Mesh the result belongs to, empty if not in mesh mode
+	NodeID   string    `parquet:"node_id,dict"`                         // Configured node ID, see NodeIdentity
+	NodeSite string    `parquet:"node_site,dict"`                       // Configured site name
+	NodeTags string    `parquet:"node_tags,dict"`                       // Configured tags, e.g. provider=geant,location=paris
 }
 
 type PathStatistics struct {
-	SrcSCIONAddr   string    // SCION src
-	DstSCIONAddr   string    // SCION dst
-	Paths          string    // interface description of the AvailablePaths, comma separated
-	Fingerprints   string    // path fingerprints, comma separated
-	Success        bool      // successCount > 0
-	MinRTT         float64   // min rtt across all paths
-	MaxRTT         float64   // max rtt across all paths
-	MinHops        int       // min # of hops across all paths
-	MaxHops        int       // max # of hops across all paths
-	LookupTime     time.Time // time ping results were stored
-	ActivePaths    int       // # of active paths (got echo reply)
-	ProbedPaths    int       // # of probed paths (sent echo request)
-	AvailablePaths int       // # of known paths
+	SrcSCIONAddr   string    `parquet:"src_scion_addr,dict"`                    // SCION src
+	DstSCIONAddr   string    `parquet:"dst_scion_addr,dict"`                    // SCION dst
+	Paths          string    `parquet:"paths"`                                  // interface description of the AvailablePaths, comma separated
+	Fingerprints   string    `parquet:"fingerprints"`                           // path fingerprints, comma separated
+	Success        bool      `parquet:"success"`                                // successCount > 0
+	MinRTT         float64   `parquet:"min_rtt"`                                // min rtt across all paths
+	MaxRTT         float64   `parquet:"max_rtt"`                                // max rtt across all paths
+	MinHops        int       `parquet:"min_hops"`                               // min # of hops across all paths
+	MaxHops        int       `parquet:"max_hops"`                               // max # of hops across all paths
+	LookupTime     time.Time `parquet:"lookup_time,timestamp(microsecond:utc)"` // time ping results were stored
+	ActivePaths    int       `parquet:"active_paths"`                           // # of active paths (got echo reply)
+	ProbedPaths    int       `parquet:"probed_paths"`                           // # of probed paths (sent echo request)
+	AvailablePaths int       `parquet:"available_paths"`                        // # of known paths
+	LocalStackDown bool      `parquet:"local_stack_down"`                       // Not probed, the local SCION stack was unavailable
+	ProbeType      string    `parquet:"probe_type,dict"`                        // Probe type the statistics are based on, scmp, udp or owd
+	MeshID         string    `parquet:"mesh_id,dict"`                           // Mesh the result belongs to, empty if not in mesh mode
+	NodeID         string    `parquet:"node_id,dict"`                           // Configured node ID, see NodeIdentity
+	NodeSite       string    `parquet:"node_site,dict"`                         // Configured site name
+	NodeTags       string    `parquet:"node_tags,dict"`                         // Configured tags, e.g. provider=geant,location=paris
 }
 
+// Forward and reverse delay of a path, estimated from the timestamps of the responder on the remote
+type OneWayDelayResult struct {
+	SrcSCIONAddr string    `parquet:"src_scion_addr,dict"`                   // SCION src
+	DstSCIONAddr string    `parquet:"dst_scion_addr,dict"`                   // SCION dst
+	Fingerprint  string    `parquet:"fingerprint,dict"`                      // Fingerprint of the path
+	ForwardDelay float64   `parquet:"forward_delay"`                         // ms, src to dst
+	ReverseDelay float64   `parquet:"reverse_delay"`                         // ms, dst to src
+	ClockOffset  float64   `parquet:"clock_offset"`                          // ms, estimated offset of the dst clock
+	SyncQuality  string    `parquet:"sync_quality,dict"`                     // How the offset was estimated, chrony or minfilter
+	SyncError    float64   `parquet:"sync_error"`                            // ms, error bound of the offset
+	ProbeTime    time.Time `parquet:"probe_time,timestamp(microsecond:utc)"` // time the probe was sent
+	MeshID       string    `parquet:"mesh_id,dict"`                          // Mesh the result belongs to, empty if not in mesh mode
+	NodeID       string    `parquet:"node_id,dict"`                          // Configured node ID, see NodeIdentity
+	NodeSite     string    `parquet:"node_site,dict"`                        // Configured site name
+	NodeTags     string    `parquet:"node_tags,dict"`                        // Configured tags, e.g. provider=geant,location=paris
+}
+
+// Tables of the results above, in the databases of the nodes and of the collector
+var resultTables = []string{"ping_results", "path_statistics", "ip_ping_results", "one_way_delay_results"}
+
 type DataExporter interface {
 	InitDaily() error
 	Close() error
 	WritePingResult(PingResult) error
 	WriteIPPingResult(IPPingResult) error
 	WritePathStatistic(PathStatistics) error
+	WriteOneWayDelayResult(OneWayDelayResult) error
+}
+
+// newExporterFromEnv creates the exporters listed in EXPORTER, comma separated: sqlite (default), push, postgres,
+// influx, otlp, mqtt, jsonl and csv. All results are labeled with the node identity, it is recorded in the
+// databases with the remotes hash. The names of the destinations by address label the results of exporters
+// without a destination table.
+func newExporterFromEnv(identity NodeIdentity, remotesHash string, destinationNames map[string]string) (DataExporter, error) {
+	names := os.Getenv("EXPORTER")
+	if names == "" {
+		names = "sqlite"
+	}
+
+	var exporters multiExporter
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "sqlite":
+			exporter := NewSQLiteExporter()
+			if err := exporter.configureFromEnv(); err != nil {
+				return nil, err
+			}
+			exporter.identity = identity
+			exporter.remotesHash = remotesHash
+			exporters = append(exporters, exporter)
+		case "postgres":
+			exporter, err := NewPostgresExporter()
+			if err != nil {
+				return nil, err
+			}
+			exporters = append(exporters, exporter)
+		case "influx":
+			exporter, err := NewInfluxExporter(destinationNames)
+			if err != nil {
+				return nil, err
+			}
+			exporters = append(exporters, exporter)
+		case "otlp":
+			exporter, err := NewOTLPExporter(identity, destinationNames)
+			if err != nil {
+				return nil, err
+			}
+			exporters = append(exporters, exporter)
+		case "mqtt":
+			exporter, err := NewMQTTExporter(identity.ID)
+			if err != nil {
+				return nil, err
+			}
+			exporters = append(exporters, exporter)
+		case streamFormatJSONL, streamFormatCSV:
+			exporter, err := NewStreamExporter(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			exporters = append(exporters, exporter)
+		case "push":
+			exporter, err := NewPushExporter(identity.ID)
+			if err != nil {
+				return nil, err
+			}
+			exporters = append(exporters, exporter)
+		default:
+			return nil, fmt.Errorf("unknown exporter %q", name)
+		}
+	}
+	if len(exporters) == 1 {
+		return newIdentityExporter(exporters[0], identity), nil
+	}
+	return newIdentityExporter(exporters, identity), nil
+}
+
+// multiExporter writes every result to all of its exporters.
+type multiExporter []DataExporter
+
+func (m multiExporter) InitDaily() error {
+	var errs []error
+	for _, exporter := range m {
+		errs = append(errs, exporter.InitDaily())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiExporter) Close() error {
+	var errs []error
+	for _, exporter := range m {
+		errs = append(errs, exporter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiExporter) WritePingResult(result PingResult) error {
+	var errs []error
+	for _, exporter := range m {
+		errs = append(errs, exporter.WritePingResult(result))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiExporter) WriteIPPingResult(result IPPingResult) error {
+	var errs []error
+	for _, exporter := range m {
+		errs = append(errs, exporter.WriteIPPingResult(result))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiExporter) WritePathStatistic(statistic PathStatistics) error {
+	var errs []error
+	for _, exporter := range m {
+		errs = append(errs, exporter.WritePathStatistic(statistic))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	var errs []error
+	for _, exporter := range m {
+		errs = append(errs, exporter.WriteOneWayDelayResult(result))
+	}
+	return errors.Join(errs...)
 }
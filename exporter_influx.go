@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInfluxBatchSize     = 500
+	defaultInfluxFlushInterval = 10 * time.Second
+	defaultInfluxMaxPending    = 100000
+	influxRequestTimeout       = 30 * time.Second
+	influxCloseTimeout         = 10 * time.Second
+	influxMaxDatagram          = 1400 // Bytes per datagram, to avoid fragmentation
+)
+
+// InfluxExporter writes the results in InfluxDB line protocol to a file, a UDP socket or an HTTP write
+// endpoint. Lines are buffered and written in batches, batches failing transiently are retried.
+type InfluxExporter struct {
+	sync.Mutex
+	sink     lineSink
+	names    map[string]string // Destination names by address
+	batching batchConfig
+	pending  []string
+	writer   *batchWriter
+}
+
+// lineSink is where the InfluxExporter writes batches of lines to.
+type lineSink interface {
+	write(ctx context.Context, lines []string) error
+	Close() error
+}
+
+// NewInfluxExporter labels the results with the destination names. It is configured with EXPORTER_INFLUX_URL,
+// e.g. file:///var/lib/multiping/results.lp, udp://localhost:8089 or http://localhost:8086/api/v2/write?org=o&bucket=b,
+// EXPORTER_INFLUX_TOKEN, EXPORTER_INFLUX_BATCH_SIZE, EXPORTER_INFLUX_FLUSH_INTERVAL and EXPORTER_INFLUX_MAX_PENDING.
+func NewInfluxExporter(names map[string]string) (*InfluxExporter, error) {
+	rawURL := os.Getenv("EXPORTER_INFLUX_URL")
+	if rawURL == "" {
+		return nil, errors.New("EXPORTER_INFLUX_URL is required to export InfluxDB line protocol")
+	}
+	sink, err := newLineSink(rawURL, os.Getenv("EXPORTER_INFLUX_TOKEN"))
+	if err != nil {
+		return nil, err
+	}
+
+	batching, err := batchConfigFromEnv("EXPORTER_INFLUX_", batchConfig{
+		batchSize:     defaultInfluxBatchSize,
+		flushInterval: defaultInfluxFlushInterval,
+		maxPending:    defaultInfluxMaxPending,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newInfluxExporter(sink, names, batching), nil
+}
+
+func newInfluxExporter(sink lineSink, names map[string]string, batching batchConfig) *InfluxExporter {
+	exporter := &InfluxExporter{sink: sink, names: names, batching: batching}
+	exporter.writer = newBatchWriter("InfluxDB", batching.flushInterval, exporter.flush,
+		func(err error) bool { return !isRejectedLines(err) })
+	return exporter
+}
+
+func newLineSink(rawURL string, token string) (lineSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXPORTER_INFLUX_URL %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return &fileLineSink{path: u.Path}, nil
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return &udpLineSink{conn: conn}, nil
+	case "http", "https":
+		return &httpLineSink{url: rawURL, token: token, client: &http.Client{Timeout: influxRequestTimeout}}, nil
+	}
+	return nil, fmt.Errorf("invalid EXPORTER_INFLUX_URL %q, expected a file, udp, http or https URL", rawURL)
+}
+
+func (exporter *InfluxExporter) status() []exporterStatus {
+	exporter.Lock()
+	defer exporter.Unlock()
+	return []exporterStatus{{Exporter: "influx", Pending: len(exporter.pending)}}
+}
+
+// InitDaily starts writing on the first call, there are no daily files to switch.
+func (exporter *InfluxExporter) InitDaily() error {
+	exporter.writer.start()
+	return nil
+}
+
+// Close writes the pending lines, waiting at most for influxCloseTimeout.
+func (exporter *InfluxExporter) Close() error {
+	exporter.writer.stop()
+
+	ctx, cancelFlush := context.WithTimeout(context.Background(), influxCloseTimeout)
+	defer cancelFlush()
+	return errors.Join(exporter.flush(ctx), exporter.sink.Close())
+}
+
+func (exporter *InfluxExporter) WritePingResult(result PingResult) error {
+	line := newInfluxLine("scion_ping").
+		tag("src", result.SrcSCIONAddr).
+		tag("dst", result.DstSCIONAddr).
+		tag("name", exporter.names[result.DstSCIONAddr]).
+		tag("fingerprint", result.Fingerprint).
+		tag("probe_type", result.ProbeType).
+		nodeTags(result.NodeID, result.NodeSite, result.NodeTags, result.MeshID).
+		boolField("success", result.Success).
+		intField("successful_pings", result.SuccessfulPings).
+		intField("max_pings", result.MaxPings).
+		boolField("local_stack_down", result.LocalStackDown)
+	if result.Success {
+		line.floatField("rtt", result.RTT)
+	}
+	return exporter.queue(line.end(result.PingTime))
+}
+
+func (exporter *InfluxExporter) WriteIPPingResult(result IPPingResult) error {
+	line := newInfluxLine("ip_ping").
+		tag("src", result.SrcAddr).
+		tag("dst", result.DstAddr).
+		tag("name", exporter.names[result.DstAddr]).
+		nodeTags(result.NodeID, result.NodeSite, result.NodeTags, result.MeshID).
+		boolField("success", result.Success)
+	if result.Success {
+		line.floatField("rtt", result.RTT)
+	}
+	return exporter.queue(line.end(result.PingTime))
+}
+
+func (exporter *InfluxExporter) WritePathStatistic(statistic PathStatistics) error {
+	line := newInfluxLine("scion_path_statistics").
+		tag("src", statistic.SrcSCIONAddr).
+		tag("dst", statistic.DstSCIONAddr).
+		tag("name", exporter.names[statistic.DstSCIONAddr]).
+		tag("probe_type", statistic.ProbeType).
+		nodeTags(statistic.NodeID, statistic.NodeSite, statistic.NodeTags, statistic.MeshID).
+		boolField("success", statistic.Success).
+		intField("active_paths", statistic.ActivePaths).
+		intField("probed_paths", statistic.ProbedPaths).
+		intField("available_paths", statistic.AvailablePaths).
+		intField("min_hops", statistic.MinHops).
+		intField("max_hops", statistic.MaxHops).
+		boolField("local_stack_down", statistic.LocalStackDown)
+	if statistic.Success {
+		line.floatField("min_rtt", statistic.MinRTT).floatField("max_rtt", statistic.MaxRTT)
+	}
+	return exporter.queue(line.end(statistic.LookupTime))
+}
+
+func (exporter *InfluxExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	line := newInfluxLine("scion_one_way_delay").
+		tag("src", result.SrcSCIONAddr).
+		tag("dst", result.DstSCIONAddr).
+		tag("name", exporter.names[result.DstSCIONAddr]).
+		tag("fingerprint", result.Fingerprint).
+		tag("sync_quality", result.SyncQuality).
+		nodeTags(result.NodeID, result.NodeSite, result.NodeTags, result.MeshID).
+		floatField("forward_delay", result.ForwardDelay).
+		floatField("reverse_delay", result.ReverseDelay).
+		floatField("clock_offset", result.ClockOffset).
+		floatField("sync_error", result.SyncError)
+	return exporter.queue(line.end(result.ProbeTime))
+}
+
+// queue wakes the writer once a batch is full, and drops the oldest lines if the sink was unavailable for too long.
+func (exporter *InfluxExporter) queue(line string) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending = append(exporter.pending, line)
+	if len(exporter.pending) >= exporter.batching.batchSize {
+		exporter.writer.notify()
+	}
+	if len(exporter.pending) > exporter.batching.maxPending {
+		exporter.pending = exporter.pending[len(exporter.pending)-exporter.batching.maxPending:]
+		return errors.New("too many lines pending for InfluxDB, dropped the oldest")
+	}
+	return nil
+}
+
+// flush writes the pending lines, they are pending again unless they were rejected.
+func (exporter *InfluxExporter) flush(ctx context.Context) error {
+	exporter.Lock()
+	lines := exporter.pending
+	exporter.pending = nil
+	exporter.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+
+	err := exporter.sink.write(ctx, lines)
+	if err != nil && !isRejectedLines(err) {
+		exporter.Lock()
+		exporter.pending = append(lines, exporter.pending...)
+		exporter.Unlock()
+	}
+	return err
+}
+
+type fileLineSink struct {
+	path string
+}
+
+func (s *fileLineSink) write(ctx context.Context, lines []string) error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, strings.Join(lines, "\n")+"\n")
+	return errors.Join(err, f.Close())
+}
+
+func (s *fileLineSink) Close() error { return nil }
+
+type udpLineSink struct {
+	conn net.Conn
+}
+
+// write sends the lines in datagrams of up to influxMaxDatagram bytes, longer lines in one datagram each.
+func (s *udpLineSink) write(ctx context.Context, lines []string) error {
+	var datagram bytes.Buffer
+	for i, line := range lines {
+		datagram.WriteString(line)
+		datagram.WriteByte('\n')
+		if i+1 < len(lines) && datagram.Len()+len(lines[i+1])+1 <= influxMaxDatagram {
+			continue
+		}
+		if _, err := s.conn.Write(datagram.Bytes()); err != nil {
+			return err
+		}
+		datagram.Reset()
+	}
+	return nil
+}
+
+func (s *udpLineSink) Close() error { return s.conn.Close() }
+
+type httpLineSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// rejectedLinesError means the endpoint refused the lines, retrying won't help.
+type rejectedLinesError struct {
+	status int
+	body   string
+}
+
+func (e *rejectedLinesError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.body)
+}
+
+func isRejectedLines(err error) bool {
+	var rejected *rejectedLinesError
+	return errors.As(err, &rejected)
+}
+
+func (s *httpLineSink) write(ctx context.Context, lines []string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge ||
+		resp.StatusCode == http.StatusUnprocessableEntity:
+		return &rejectedLinesError{status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	default:
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+}
+
+func (s *httpLineSink) Close() error { return nil }
+
+// influxLine builds a line of the line protocol, tags have to be added before fields.
+type influxLine struct {
+	tags   strings.Builder
+	fields strings.Builder
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+func newInfluxLine(measurement string) *influxLine {
+	line := &influxLine{}
+	line.tags.WriteString(measurementEscaper.Replace(measurement))
+	return line
+}
+
+// tag adds the tag, empty values are not allowed in line protocol and skipped.
+func (l *influxLine) tag(key string, value string) *influxLine {
+	if value == "" {
+		return l
+	}
+	l.tags.WriteString("," + tagEscaper.Replace(key) + "=" + tagEscaper.Replace(value))
+	return l
+}
+
+// nodeTags adds the node identity and the mesh, and the configured node tags unless they collide with them.
+func (l *influxLine) nodeTags(nodeID, site, tags, mesh string) *influxLine {
+	l.tag("node", nodeID).tag("site", site).tag("mesh", mesh)
+	parsed, _ := parseNodeTags(tags)
+	keys := make([]string, 0, len(parsed))
+	for key := range parsed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "src", "dst", "name", "fingerprint", "probe_type", "sync_quality", "node", "site", "mesh":
+			continue
+		}
+		l.tag(key, parsed[key])
+	}
+	return l
+}
+
+func (l *influxLine) field(key string, value string) *influxLine {
+	if l.fields.Len() > 0 {
+		l.fields.WriteByte(',')
+	}
+	l.fields.WriteString(tagEscaper.Replace(key) + "=" + value)
+	return l
+}
+
+func (l *influxLine) floatField(key string, value float64) *influxLine {
+	return l.field(key, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (l *influxLine) intField(key string, value int) *influxLine {
+	return l.field(key, strconv.Itoa(value)+"i")
+}
+
+func (l *influxLine) boolField(key string, value bool) *influxLine {
+	return l.field(key, strconv.FormatBool(value))
+}
+
+// end returns the line with the timestamp in nanoseconds.
+func (l *influxLine) end(t time.Time) string {
+	return l.tags.String() + " " + l.fields.String() + " " + strconv.FormatInt(t.UnixNano(), 10)
+}
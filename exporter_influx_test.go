@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInfluxLineProtocol(t *testing.T) {
+	exporter := newInfluxExporter(nil, map[string]string{"1-ff00:0:111,10.0.0.2:30041": "Zurich Lab"},
+		batchConfig{batchSize: defaultInfluxBatchSize, flushInterval: defaultInfluxFlushInterval, maxPending: defaultInfluxMaxPending})
+	pingTime := time.Unix(1714564800, 5)
+	exporter.WritePingResult(PingResult{SrcSCIONAddr: "1-ff00:0:110,10.0.0.1", DstSCIONAddr: "1-ff00:0:111,10.0.0.2:30041",
+		Success: true, RTT: 12.5, Fingerprint: "fp", PingTime: pingTime, SuccessfulPings: 2, MaxPings: 3,
+		ProbeType: probeTypeSCMP, NodeID: "node-1", NodeTags: "provider=geant,node=ignored"})
+	exporter.WriteIPPingResult(IPPingResult{SrcAddr: "10.0.0.1", DstAddr: "10.0.0.3", PingTime: pingTime})
+
+	expected := []string{
+		`scion_ping,src=1-ff00:0:110\,10.0.0.1,dst=1-ff00:0:111\,10.0.0.2:30041,name=Zurich\ Lab,fingerprint=fp,` +
+			`probe_type=scmp,node=node-1,provider=geant success=true,successful_pings=2i,max_pings=3i,` +
+			`local_stack_down=false,rtt=12.5 1714564800000000005`,
+		`ip_ping,src=10.0.0.1,dst=10.0.0.3 success=false 1714564800000000005`,
+	}
+	if strings.Join(exporter.pending, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected lines\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(exporter.pending, "\n"))
+	}
+}
+
+func TestInfluxHTTPSinkRetries(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, strings.Split(string(body), "\n")...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	t.Setenv("EXPORTER_INFLUX_URL", server.URL+"/api/v2/write?org=o&bucket=b")
+	t.Setenv("EXPORTER_INFLUX_TOKEN", "secret")
+	t.Setenv("EXPORTER_INFLUX_BATCH_SIZE", "2")
+	exporter, err := NewInfluxExporter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		exporter.WriteIPPingResult(IPPingResult{DstAddr: "10.0.0.3", PingTime: time.Now()})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	exporter.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || requests != 2 {
+		t.Errorf("Expected 2 lines after a retry, got %d lines in %d requests", len(received), requests)
+	}
+}
+
+func TestInfluxFileAndUDPSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.lp")
+	sink, err := newLineSink("file://"+path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.write(context.Background(), []string{"a x=1i 1", "b x=2i 2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "a x=1i 1\nb x=2i 2\na x=1i 1\nb x=2i 2\n" {
+		t.Errorf("Unexpected file content %q", data)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err = newLineSink("udp://"+conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	long := "a x=\"" + strings.Repeat("x", influxMaxDatagram) + "\" 1"
+	if err := sink.write(context.Background(), []string{"a x=1i 1", "b x=2i 2", long}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2*influxMaxDatagram)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, expected := range []string{"a x=1i 1\nb x=2i 2\n", long + "\n"} {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != expected {
+			t.Errorf("Expected datagram %.40q, got %.40q: %v", expected, buf[:n], err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTTopicPrefix = "multiping"
+	mqttRetryInterval      = 10 * time.Second
+	mqttCloseTimeout       = 2 * time.Second
+)
+
+// MQTTExporter publishes the results as JSON, with the keys of the jsonl exporter, to topics like
+// multiping/<node>/<dst>/ping for live dashboards. Results are dropped while the broker is unreachable.
+type MQTTExporter struct {
+	sync.Mutex
+	client mqtt.Client
+	prefix string
+	node   string
+	qos    byte
+	retain bool                 // Whether results are retained as last status of the destination
+	paths  map[string]pathState // Last paths by destination, to publish changes
+}
+
+type pathState struct {
+	fingerprints []string
+	active       int
+}
+
+type mqttPath struct {
+	Fingerprint string `json:"fingerprint"`
+	Path        string `json:"path"`
+}
+
+// NewMQTTExporter is configured with EXPORTER_MQTT_BROKER, e.g. tcp://localhost:1883, EXPORTER_MQTT_TOPIC_PREFIX,
+// EXPORTER_MQTT_QOS, EXPORTER_MQTT_RETAIN, EXPORTER_MQTT_CLIENT_ID, EXPORTER_MQTT_USERNAME and EXPORTER_MQTT_PASSWORD.
+func NewMQTTExporter(node string) (*MQTTExporter, error) {
+	broker := os.Getenv("EXPORTER_MQTT_BROKER")
+	if broker == "" {
+		return nil, errors.New("EXPORTER_MQTT_BROKER is required to publish to MQTT")
+	}
+	exporter := &MQTTExporter{
+		prefix: defaultMQTTTopicPrefix,
+		node:   node,
+		paths:  make(map[string]pathState),
+	}
+	if prefix := os.Getenv("EXPORTER_MQTT_TOPIC_PREFIX"); prefix != "" {
+		exporter.prefix = strings.TrimSuffix(prefix, "/")
+	}
+	if qos := os.Getenv("EXPORTER_MQTT_QOS"); qos != "" {
+		n, err := strconv.Atoi(qos)
+		if err != nil || n < 0 || n > 2 {
+			return nil, fmt.Errorf("invalid EXPORTER_MQTT_QOS %q, expected 0, 1 or 2", qos)
+		}
+		exporter.qos = byte(n)
+	}
+	if retain := os.Getenv("EXPORTER_MQTT_RETAIN"); retain != "" {
+		b, err := strconv.ParseBool(retain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXPORTER_MQTT_RETAIN %q", retain)
+		}
+		exporter.retain = b
+	}
+	clientID := os.Getenv("EXPORTER_MQTT_CLIENT_ID")
+	if clientID == "" {
+		clientID = "multiping-" + node
+	}
+
+	status := exporter.statusTopic()
+	options := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(os.Getenv("EXPORTER_MQTT_USERNAME")).
+		SetPassword(os.Getenv("EXPORTER_MQTT_PASSWORD")).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttRetryInterval).
+		SetWill(status, mqttStatus("offline"), 1, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			Log.Info("Connected to MQTT broker ", broker)
+			client.Publish(status, 1, true, mqttStatus("online"))
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			Log.Error("Lost connection to MQTT broker ", broker, ": ", err)
+		})
+	exporter.client = mqtt.NewClient(options)
+	return exporter, nil
+}
+
+// InitDaily connects on the first call, retrying in the background while the broker is unreachable.
+func (exporter *MQTTExporter) InitDaily() error {
+	if !exporter.client.IsConnected() {
+		exporter.client.Connect()
+	}
+	return nil
+}
+
+// Close publishes the offline status and disconnects.
+func (exporter *MQTTExporter) Close() error {
+	if exporter.client.IsConnectionOpen() {
+		exporter.client.Publish(exporter.statusTopic(), 1, true, mqttStatus("offline")).WaitTimeout(mqttCloseTimeout)
+	}
+	exporter.client.Disconnect(uint(mqttCloseTimeout.Milliseconds()))
+	return nil
+}
+
+func (exporter *MQTTExporter) statusTopic() string {
+	return exporter.prefix + "/" + topicLevel(exporter.node) + "/status"
+}
+
+func mqttStatus(status string) string {
+	return `{"status":"` + status + `"}`
+}
+
+// topicLevel replaces the characters that can't be in a level of a topic name.
+func topicLevel(value string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(value)
+}
+
+func (exporter *MQTTExporter) publish(dst string, kind string, fields []streamField) error {
+	_, err := exporter.send(dst, kind, fields)
+	return err
+}
+
+// send returns whether the message was handed to the client, messages are dropped while disconnected.
+func (exporter *MQTTExporter) send(dst string, kind string, fields []streamField) (bool, error) {
+	if !exporter.client.IsConnectionOpen() {
+		// Live data, it's not worth keeping it for later
+		return false, nil
+	}
+	payload, err := encodeJSONFields(fields)
+	if err != nil {
+		return false, err
+	}
+	topic := exporter.prefix + "/" + topicLevel(exporter.node) + "/" + topicLevel(dst) + "/" + kind
+	token := exporter.client.Publish(topic, exporter.qos, exporter.retain, payload)
+	select {
+	case <-token.Done():
+		return token.Error() == nil, token.Error()
+	default:
+		// Not acknowledged yet, QoS 1 or 2
+		return true, nil
+	}
+}
+
+func (exporter *MQTTExporter) WritePingResult(result PingResult) error {
+	return exporter.publish(result.DstSCIONAddr, "ping", pingFields(result))
+}
+
+func (exporter *MQTTExporter) WriteIPPingResult(result IPPingResult) error {
+	return exporter.publish(result.DstAddr, "ip_ping", ipPingFields(result))
+}
+
+// WritePathStatistic publishes a path change event if paths of the destination appeared or disappeared, or
+// the number of active paths changed. The paths are only remembered once the event was handed to the client, so
+// changes while the broker is unreachable are published after reconnecting.
+func (exporter *MQTTExporter) WritePathStatistic(statistic PathStatistics) error {
+	if statistic.LocalStackDown {
+		// No paths known, not a change of the paths
+		return nil
+	}
+	fingerprints := splitList(statistic.Fingerprints)
+	paths := splitList(statistic.Paths)
+
+	exporter.Lock()
+	defer exporter.Unlock()
+	previous, known := exporter.paths[statistic.DstSCIONAddr]
+
+	added := []mqttPath{}
+	for i, fingerprint := range fingerprints {
+		if !containsString(previous.fingerprints, fingerprint) {
+			path := mqttPath{Fingerprint: fingerprint}
+			if i < len(paths) {
+				path.Path = paths[i]
+			}
+			added = append(added, path)
+		}
+	}
+	removed := []string{}
+	for _, fingerprint := range previous.fingerprints {
+		if !containsString(fingerprints, fingerprint) {
+			removed = append(removed, fingerprint)
+		}
+	}
+	if known && len(added) == 0 && len(removed) == 0 && previous.active == statistic.ActivePaths {
+		return nil
+	}
+
+	fields := []streamField{{"type", "path_change"}, {"time", statistic.LookupTime.UTC()}}
+	fields = append(fields, nodeFields(statistic.NodeID, statistic.NodeSite, statistic.NodeTags, statistic.MeshID)...)
+	fields = append(fields, []streamField{
+		{"src", statistic.SrcSCIONAddr},
+		{"dst", statistic.DstSCIONAddr},
+		{"probe_type", statistic.ProbeType},
+		{"available_paths", statistic.AvailablePaths},
+		{"active_paths", statistic.ActivePaths},
+		{"previous_active_paths", previous.active},
+		{"added", added},
+		{"removed", removed},
+	}...)
+	sent, err := exporter.send(statistic.DstSCIONAddr, "paths", fields)
+	if sent {
+		exporter.paths[statistic.DstSCIONAddr] = pathState{fingerprints: fingerprints, active: statistic.ActivePaths}
+	}
+	return err
+}
+
+func (exporter *MQTTExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	return exporter.publish(result.DstSCIONAddr, "one_way_delay", oneWayDelayFields(result))
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type mqttMessage struct {
+	topic    string
+	retained bool
+	payload  map[string]interface{}
+}
+
+// testMQTTClient records the published messages instead of connecting to a broker.
+type testMQTTClient struct {
+	mqtt.Client
+	disconnected bool
+	messages     []mqttMessage
+}
+
+func (c *testMQTTClient) IsConnectionOpen() bool { return !c.disconnected }
+
+func (c *testMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	message := mqttMessage{topic: topic, retained: retained}
+	json.Unmarshal(payload.([]byte), &message.payload)
+	c.messages = append(c.messages, message)
+	return &mqtt.DummyToken{}
+}
+
+func TestMQTTExporter(t *testing.T) {
+	client := &testMQTTClient{}
+	exporter := &MQTTExporter{client: client, prefix: "multiping", node: "node-1", retain: true, paths: make(map[string]pathState)}
+
+	now := time.Now()
+	dst := "1-ff00:0:111,10.0.0.2"
+	exporter.WritePingResult(PingResult{DstSCIONAddr: dst, Success: true, RTT: 12, PingTime: now})
+	statistic := PathStatistics{DstSCIONAddr: dst, Fingerprints: "aa,bb", Paths: "1>2,3>4", ActivePaths: 2, LookupTime: now}
+	exporter.WritePathStatistic(statistic)
+	// Unchanged
+	exporter.WritePathStatistic(statistic)
+	statistic.Fingerprints, statistic.Paths, statistic.ActivePaths = "bb,cc", "3>4,5>6", 1
+	exporter.WritePathStatistic(statistic)
+
+	if len(client.messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(client.messages))
+	}
+	ping := client.messages[0]
+	if ping.topic != "multiping/node-1/1-ff00:0:111,10.0.0.2/ping" || !ping.retained || ping.payload["rtt"] != 12.0 {
+		t.Errorf("Unexpected ping message %+v", ping)
+	}
+	change := client.messages[2]
+	if change.topic != "multiping/node-1/1-ff00:0:111,10.0.0.2/paths" || change.payload["type"] != "path_change" {
+		t.Fatalf("Unexpected path change message %+v", change)
+	}
+	added := change.payload["added"].([]interface{})
+	removed := change.payload["removed"].([]interface{})
+	if len(added) != 1 || added[0].(map[string]interface{})["path"] != "5>6" || len(removed) != 1 || removed[0] != "aa" {
+		t.Errorf("Unexpected path change %v", change.payload)
+	}
+	if change.payload["active_paths"] != 1.0 || change.payload["previous_active_paths"] != 2.0 {
+		t.Errorf("Unexpected active paths %v", change.payload)
+	}
+}
+
+func TestMQTTExporterPathChangeWhileDisconnected(t *testing.T) {
+	client := &testMQTTClient{}
+	exporter := &MQTTExporter{client: client, prefix: "multiping", node: "node-1", paths: make(map[string]pathState)}
+
+	dst := "1-ff00:0:111,10.0.0.2"
+	statistic := PathStatistics{DstSCIONAddr: dst, Fingerprints: "aa", Paths: "1>2", ActivePaths: 1, LookupTime: time.Now()}
+	exporter.WritePathStatistic(statistic)
+
+	client.disconnected = true
+	statistic.Fingerprints, statistic.Paths = "bb", "3>4"
+	exporter.WritePathStatistic(statistic)
+	client.disconnected = false
+	exporter.WritePathStatistic(statistic)
+
+	if len(client.messages) != 2 {
+		t.Fatalf("Expected the change while disconnected to be published after reconnecting, got %d messages", len(client.messages))
+	}
+	removed := client.messages[1].payload["removed"].([]interface{})
+	if len(removed) != 1 || removed[0] != "aa" {
+		t.Errorf("Unexpected path change %v", client.messages[1].payload)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const otlpCloseTimeout = 10 * time.Second
+
+// Bucket boundaries of the RTT and delay histograms, in ms
+var otlpRTTBuckets = []float64{1, 2, 5, 10, 20, 30, 50, 75, 100, 150, 200, 300, 500, 750, 1000, 2000, 5000}
+
+// OTLPExporter records the results as OpenTelemetry metrics, which are pushed periodically to an OTLP
+// collector. The node identity and version are attributes of the resource, not of every data point.
+type OTLPExporter struct {
+	provider *sdkmetric.MeterProvider
+	names    map[string]string // Destination names by address
+
+	pingRTT        metric.Float64Histogram
+	pingSent       metric.Int64Counter
+	pingLost       metric.Int64Counter
+	ipPingRTT      metric.Float64Histogram
+	ipPingSent     metric.Int64Counter
+	ipPingLost     metric.Int64Counter
+	pathsAvailable metric.Int64Gauge
+	pathsProbed    metric.Int64Gauge
+	pathsActive    metric.Int64Gauge
+	forwardDelay   metric.Float64Histogram
+	reverseDelay   metric.Float64Histogram
+}
+
+// NewOTLPExporter is configured with the standard OpenTelemetry variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT,
+// OTEL_EXPORTER_OTLP_PROTOCOL (grpc or http/protobuf), OTEL_EXPORTER_OTLP_HEADERS, OTEL_METRIC_EXPORT_INTERVAL
+// and OTEL_RESOURCE_ATTRIBUTES.
+func NewOTLPExporter(identity NodeIdentity, names map[string]string) (*OTLPExporter, error) {
+	ctx := context.Background()
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	var exporter sdkmetric.Exporter
+	var err error
+	switch protocol {
+	case "", "grpc":
+		exporter, err = otlpmetricgrpc.New(ctx)
+	case "http/protobuf":
+		exporter, err = otlpmetrichttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newOTLPExporter(sdkmetric.NewPeriodicReader(exporter), identity, names)
+}
+
+func newOTLPExporter(reader sdkmetric.Reader, identity NodeIdentity, names map[string]string) (*OTLPExporter, error) {
+	attributes := []attribute.KeyValue{
+		semconv.ServiceName("scion-go-multiping"),
+		semconv.ServiceVersion(versionString),
+		semconv.ServiceInstanceID(identity.ID),
+		attribute.String("multiping.node.id", identity.ID),
+	}
+	if identity.Site != "" {
+		attributes = append(attributes, attribute.String("multiping.node.site", identity.Site))
+	}
+	keys := make([]string, 0, len(identity.Tags))
+	for key := range identity.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attributes = append(attributes, attribute.String("multiping.node.tag."+key, identity.Tags[key]))
+	}
+	// Attributes of OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.New(context.Background(),
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attributes...),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter := &OTLPExporter{
+		provider: sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(reader)),
+		names:    names,
+	}
+	meter := exporter.provider.Meter("github.com/netsec-ethz/scion-go-multiping", metric.WithInstrumentationVersion(versionString))
+	histogram := func(name string, description string) metric.Float64Histogram {
+		if err != nil {
+			return nil
+		}
+		var h metric.Float64Histogram
+		h, err = meter.Float64Histogram(name, metric.WithUnit("ms"), metric.WithDescription(description),
+			metric.WithExplicitBucketBoundaries(otlpRTTBuckets...))
+		return h
+	}
+	counter := func(name string, description string) metric.Int64Counter {
+		if err != nil {
+			return nil
+		}
+		var c metric.Int64Counter
+		c, err = meter.Int64Counter(name, metric.WithUnit("{ping}"), metric.WithDescription(description))
+		return c
+	}
+	gauge := func(name string, description string) metric.Int64Gauge {
+		if err != nil {
+			return nil
+		}
+		var g metric.Int64Gauge
+		g, err = meter.Int64Gauge(name, metric.WithUnit("{path}"), metric.WithDescription(description))
+		return g
+	}
+	exporter.pingRTT = histogram("multiping.scion.rtt", "RTT of the best path of a destination")
+	exporter.pingSent = counter("multiping.scion.pings.sent", "SCION pings sent, one per path")
+	exporter.pingLost = counter("multiping.scion.pings.lost", "SCION pings without reply")
+	exporter.ipPingRTT = histogram("multiping.ip.rtt", "RTT of IP pings")
+	exporter.ipPingSent = counter("multiping.ip.pings.sent", "IP pings sent")
+	exporter.ipPingLost = counter("multiping.ip.pings.lost", "IP pings without reply")
+	exporter.pathsAvailable = gauge("multiping.scion.paths.available", "Known paths to a destination")
+	exporter.pathsProbed = gauge("multiping.scion.paths.probed", "Paths to a destination that were probed")
+	exporter.pathsActive = gauge("multiping.scion.paths.active", "Paths to a destination that replied")
+	exporter.forwardDelay = histogram("multiping.scion.delay.forward", "One-way delay from the node to the destination")
+	exporter.reverseDelay = histogram("multiping.scion.delay.reverse", "One-way delay from the destination to the node")
+	if err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
+
+// InitDaily does nothing, the metrics are pushed periodically.
+func (exporter *OTLPExporter) InitDaily() error {
+	return nil
+}
+
+// Close pushes the last metrics, waiting at most for otlpCloseTimeout.
+func (exporter *OTLPExporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpCloseTimeout)
+	defer cancel()
+	return exporter.provider.Shutdown(ctx)
+}
+
+func (exporter *OTLPExporter) attributes(dst string, probeType string, mesh string) metric.MeasurementOption {
+	attributes := []attribute.KeyValue{attribute.String("dst", dst)}
+	if name := exporter.names[dst]; name != "" {
+		attributes = append(attributes, attribute.String("name", name))
+	}
+	if probeType != "" {
+		attributes = append(attributes, attribute.String("probe_type", probeType))
+	}
+	if mesh != "" {
+		attributes = append(attributes, attribute.String("mesh", mesh))
+	}
+	return metric.WithAttributes(attributes...)
+}
+
+func (exporter *OTLPExporter) WritePingResult(result PingResult) error {
+	if result.LocalStackDown {
+		// Not pinged
+		return nil
+	}
+	ctx := context.Background()
+	attributes := exporter.attributes(result.DstSCIONAddr, result.ProbeType, result.MeshID)
+	exporter.pingSent.Add(ctx, int64(result.MaxPings), attributes)
+	exporter.pingLost.Add(ctx, int64(result.MaxPings-result.SuccessfulPings), attributes)
+	if result.Success {
+		exporter.pingRTT.Record(ctx, result.RTT, attributes)
+	}
+	return nil
+}
+
+func (exporter *OTLPExporter) WriteIPPingResult(result IPPingResult) error {
+	ctx := context.Background()
+	attributes := exporter.attributes(result.DstAddr, "", result.MeshID)
+	exporter.ipPingSent.Add(ctx, 1, attributes)
+	if result.Success {
+		exporter.ipPingRTT.Record(ctx, result.RTT, attributes)
+	} else {
+		exporter.ipPingLost.Add(ctx, 1, attributes)
+	}
+	return nil
+}
+
+func (exporter *OTLPExporter) WritePathStatistic(statistic PathStatistics) error {
+	if statistic.LocalStackDown {
+		return nil
+	}
+	ctx := context.Background()
+	attributes := exporter.attributes(statistic.DstSCIONAddr, statistic.ProbeType, statistic.MeshID)
+	exporter.pathsAvailable.Record(ctx, int64(statistic.AvailablePaths), attributes)
+	exporter.pathsProbed.Record(ctx, int64(statistic.ProbedPaths), attributes)
+	exporter.pathsActive.Record(ctx, int64(statistic.ActivePaths), attributes)
+	return nil
+}
+
+func (exporter *OTLPExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	ctx := context.Background()
+	attributes := exporter.attributes(result.DstSCIONAddr, "", result.MeshID)
+	exporter.forwardDelay.Record(ctx, result.ForwardDelay, attributes)
+	exporter.reverseDelay.Record(ctx, result.ReverseDelay, attributes)
+	return nil
+}
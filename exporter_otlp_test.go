@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	identity := NodeIdentity{ID: "node-1", Site: "zurich", Tags: map[string]string{"provider": "geant"}}
+	exporter, err := newOTLPExporter(reader, identity, map[string]string{"1-ff00:0:111,10.0.0.2": "ETH"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	now := time.Now()
+	dst := "1-ff00:0:111,10.0.0.2"
+	exporter.WritePingResult(PingResult{DstSCIONAddr: dst, Success: true, RTT: 12, SuccessfulPings: 3, MaxPings: 4, ProbeType: "scmp", PingTime: now})
+	exporter.WritePingResult(PingResult{DstSCIONAddr: dst, MaxPings: 4, LocalStackDown: true, ProbeType: "scmp", PingTime: now})
+	exporter.WritePathStatistic(PathStatistics{DstSCIONAddr: dst, AvailablePaths: 5, ProbedPaths: 4, ActivePaths: 3, ProbeType: "scmp", LookupTime: now})
+
+	var metrics metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[attribute.Key]string{
+		"service.instance.id":         "node-1",
+		"multiping.node.site":         "zurich",
+		"multiping.node.tag.provider": "geant",
+	} {
+		if value, ok := metrics.Resource.Set().Value(key); !ok || value.AsString() != expected {
+			t.Errorf("Expected resource attribute %s=%s, got %v", key, expected, value.AsString())
+		}
+	}
+
+	found := make(map[string]metricdata.Aggregation)
+	for _, scope := range metrics.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			found[m.Name] = m.Data
+		}
+	}
+	sent := found["multiping.scion.pings.sent"].(metricdata.Sum[int64]).DataPoints[0]
+	lost := found["multiping.scion.pings.lost"].(metricdata.Sum[int64]).DataPoints[0]
+	if sent.Value != 4 || lost.Value != 1 {
+		t.Errorf("Expected 4 sent and 1 lost, got %d and %d", sent.Value, lost.Value)
+	}
+	if name, _ := sent.Attributes.Value("name"); name.AsString() != "ETH" {
+		t.Errorf("Expected the destination name, got %v", sent.Attributes)
+	}
+	rtt := found["multiping.scion.rtt"].(metricdata.Histogram[float64]).DataPoints[0]
+	if rtt.Count != 1 || rtt.Sum != 12 {
+		t.Errorf("Unexpected RTT histogram %+v", rtt)
+	}
+	active := found["multiping.scion.paths.active"].(metricdata.Gauge[int64]).DataPoints[0]
+	if active.Value != 3 {
+		t.Errorf("Expected 3 active paths, got %d", active.Value)
+	}
+}
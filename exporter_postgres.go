@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	defaultPostgresBatchSize     = 500
+	defaultPostgresFlushInterval = 5 * time.Second
+	defaultPostgresMaxConns      = 4
+	defaultPostgresMaxPending    = 100000
+	postgresCloseTimeout         = 10 * time.Second
+)
+
+// Time column of each result table, the hypertables are partitioned by it
+var postgresTimeColumns = map[string]string{
+	"ping_results":          "ping_time",
+	"path_statistics":       "lookup_time",
+	"ip_ping_results":       "ping_time",
+	"one_way_delay_results": "probe_time",
+}
+
+// PostgresExporter writes the results to PostgreSQL, optionally with TimescaleDB hypertables. Results are
+// buffered and written in batches with COPY, batches failing transiently are retried. It connects in the
+// background, results are buffered until the database is reachable.
+type PostgresExporter struct {
+	sync.Mutex
+	config    *pgxpool.Config
+	timescale bool
+	batching  batchConfig
+	conn      postgresConn // Nil until connected and the tables were created
+	connect   func(ctx context.Context) (postgresConn, error)
+	schemas   map[string]*schema.Schema
+	pending   resultBatch
+	writer    *batchWriter
+}
+
+// postgresConn writes the rows of a table, it is the pgxpool.Pool and fakes in the tests.
+type postgresConn interface {
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, rows pgx.CopyFromSource) (int64, error)
+	Close()
+}
+
+// NewPostgresExporter is configured with EXPORTER_POSTGRES_DSN, EXPORTER_POSTGRES_TIMESCALE,
+// EXPORTER_POSTGRES_BATCH_SIZE, EXPORTER_POSTGRES_FLUSH_INTERVAL, EXPORTER_POSTGRES_MAX_CONNS and
+// EXPORTER_POSTGRES_MAX_PENDING.
+func NewPostgresExporter() (*PostgresExporter, error) {
+	dsn := os.Getenv("EXPORTER_POSTGRES_DSN")
+	if dsn == "" {
+		return nil, errors.New("EXPORTER_POSTGRES_DSN is required to export to PostgreSQL")
+	}
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXPORTER_POSTGRES_DSN: %w", err)
+	}
+
+	exporter := &PostgresExporter{
+		config:    config,
+		timescale: os.Getenv("EXPORTER_POSTGRES_TIMESCALE") == "true",
+	}
+	exporter.connect = exporter.connectPool
+	exporter.batching, err = batchConfigFromEnv("EXPORTER_POSTGRES_", batchConfig{
+		batchSize:     defaultPostgresBatchSize,
+		flushInterval: defaultPostgresFlushInterval,
+		maxPending:    defaultPostgresMaxPending,
+	})
+	if err != nil {
+		return nil, err
+	}
+	maxConns, err := positiveIntFromEnv("EXPORTER_POSTGRES_MAX_CONNS", defaultPostgresMaxConns)
+	if err != nil {
+		return nil, err
+	}
+	exporter.config.MaxConns = int32(maxConns)
+	exporter.writer = newBatchWriter("PostgreSQL", exporter.batching.flushInterval, exporter.flush, func(err error) bool {
+		return isTransientPostgresError(err) || !exporter.connected()
+	})
+
+	exporter.schemas = make(map[string]*schema.Schema)
+	cache := &sync.Map{}
+	for _, model := range []interface{}{&PingResult{}, &PathStatistics{}, &IPPingResult{}, &OneWayDelayResult{}} {
+		s, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			return nil, err
+		}
+		exporter.schemas[s.Table] = s
+	}
+	return exporter, nil
+}
+
+func (exporter *PostgresExporter) status() []exporterStatus {
+	exporter.Lock()
+	defer exporter.Unlock()
+	return []exporterStatus{{Exporter: "postgres", Pending: exporter.pending.rows()}}
+}
+
+// InitDaily starts writing on the first call, there are no daily databases to switch. Connecting and
+// creating the tables is retried in the background until the database is reachable.
+func (exporter *PostgresExporter) InitDaily() error {
+	exporter.writer.start()
+	return nil
+}
+
+func (exporter *PostgresExporter) connected() bool {
+	exporter.Lock()
+	defer exporter.Unlock()
+	return exporter.conn != nil
+}
+
+// connectPool connects and creates the tables.
+func (exporter *PostgresExporter) connectPool(ctx context.Context) (postgresConn, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, exporter.config.Copy())
+	if err != nil {
+		return nil, err
+	}
+	if err := migratePostgres(ctx, pool, exporter.timescale); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	Log.Info("Writing results to PostgreSQL ", exporter.config.ConnConfig.Host, "/", exporter.config.ConnConfig.Database)
+	return pool, nil
+}
+
+// migratePostgres creates the result tables from the gorm models, as hypertables with TimescaleDB.
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool, timescale bool) error {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: stdlib.OpenDBFromPool(pool)}), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	defer closeDb(db)
+	db = db.WithContext(ctx)
+
+	if err := db.AutoMigrate(&PingResult{}, &PathStatistics{}, &IPPingResult{}, &OneWayDelayResult{}); err != nil {
+		return err
+	}
+	if !timescale {
+		return nil
+	}
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS timescaledb").Error; err != nil {
+		return err
+	}
+	for table, column := range postgresTimeColumns {
+		err := db.Exec("SELECT create_hypertable(?, ?, if_not_exists => TRUE, migrate_data => TRUE)", table, column).Error
+		if err != nil {
+			return fmt.Errorf("creating hypertable %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// Close writes the pending results, waiting at most for postgresCloseTimeout.
+func (exporter *PostgresExporter) Close() error {
+	if !exporter.writer.stop() {
+		return nil
+	}
+
+	ctx, cancelFlush := context.WithTimeout(context.Background(), postgresCloseTimeout)
+	defer cancelFlush()
+	err := exporter.flush(ctx)
+	exporter.Lock()
+	if exporter.conn != nil {
+		exporter.conn.Close()
+		exporter.conn = nil
+	}
+	exporter.Unlock()
+	return err
+}
+
+func (exporter *PostgresExporter) WritePingResult(result PingResult) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.PingResults = append(exporter.pending.PingResults, result)
+	return exporter.queuedLocked()
+}
+
+func (exporter *PostgresExporter) WriteIPPingResult(result IPPingResult) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.IPPingResults = append(exporter.pending.IPPingResults, result)
+	return exporter.queuedLocked()
+}
+
+func (exporter *PostgresExporter) WritePathStatistic(statistic PathStatistics) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.PathStatistics = append(exporter.pending.PathStatistics, statistic)
+	return exporter.queuedLocked()
+}
+
+func (exporter *PostgresExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.OneWayDelayResults = append(exporter.pending.OneWayDelayResults, result)
+	return exporter.queuedLocked()
+}
+
+// queuedLocked wakes the writer once a batch is full, and drops the oldest results if the database was
+// unreachable for too long.
+func (exporter *PostgresExporter) queuedLocked() error {
+	rows := exporter.pending.rows()
+	if rows >= exporter.batching.batchSize {
+		exporter.writer.notify()
+	}
+	if rows <= exporter.batching.maxPending {
+		return nil
+	}
+	p := &exporter.pending
+	switch {
+	case len(p.PingResults) > 0:
+		p.PingResults = p.PingResults[1:]
+	case len(p.PathStatistics) > 0:
+		p.PathStatistics = p.PathStatistics[1:]
+	case len(p.IPPingResults) > 0:
+		p.IPPingResults = p.IPPingResults[1:]
+	case len(p.OneWayDelayResults) > 0:
+		p.OneWayDelayResults = p.OneWayDelayResults[1:]
+	}
+	return errors.New("too many results pending for PostgreSQL, dropped the oldest")
+}
+
+// flush writes the pending results, connecting first if needed. Each table is written on its own: rows
+// failing transiently are pending again, rows the database refuses are dropped without holding up the
+// other tables.
+func (exporter *PostgresExporter) flush(ctx context.Context) error {
+	exporter.Lock()
+	conn := exporter.conn
+	exporter.Unlock()
+	if conn == nil {
+		var err error
+		if conn, err = exporter.connect(ctx); err != nil {
+			return fmt.Errorf("connecting: %w", err)
+		}
+		exporter.Lock()
+		exporter.conn = conn
+		exporter.Unlock()
+	}
+
+	exporter.Lock()
+	batch := exporter.pending
+	exporter.pending = resultBatch{}
+	exporter.Unlock()
+	if batch.rows() == 0 {
+		return nil
+	}
+
+	var errs []error
+	retry := func(rows int, err error) bool {
+		if err == nil {
+			return false
+		}
+		if isTransientPostgresError(err) || ctx.Err() != nil {
+			errs = append(errs, err)
+			return true
+		}
+		Log.Error("Failed to write ", rows, " results to PostgreSQL, dropped them: ", err)
+		return false
+	}
+	var failed resultBatch
+	if retry(len(batch.PingResults), exporter.copyRows(ctx, conn, batch.PingResults)) {
+		failed.PingResults = batch.PingResults
+	}
+	if retry(len(batch.PathStatistics), exporter.copyRows(ctx, conn, batch.PathStatistics)) {
+		failed.PathStatistics = batch.PathStatistics
+	}
+	if retry(len(batch.IPPingResults), exporter.copyRows(ctx, conn, batch.IPPingResults)) {
+		failed.IPPingResults = batch.IPPingResults
+	}
+	if retry(len(batch.OneWayDelayResults), exporter.copyRows(ctx, conn, batch.OneWayDelayResults)) {
+		failed.OneWayDelayResults = batch.OneWayDelayResults
+	}
+	if failed.rows() > 0 {
+		// Results written in the meantime go after the failed ones
+		exporter.Lock()
+		exporter.pending.PingResults = append(failed.PingResults, exporter.pending.PingResults...)
+		exporter.pending.PathStatistics = append(failed.PathStatistics, exporter.pending.PathStatistics...)
+		exporter.pending.IPPingResults = append(failed.IPPingResults, exporter.pending.IPPingResults...)
+		exporter.pending.OneWayDelayResults = append(failed.OneWayDelayResults, exporter.pending.OneWayDelayResults...)
+		exporter.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// copyRows writes a slice of results with COPY, with the columns of their gorm model.
+func (exporter *PostgresExporter) copyRows(ctx context.Context, conn postgresConn, rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	if v.Len() == 0 {
+		return nil
+	}
+	s, err := exporter.schemaOf(v.Type().Elem())
+	if err != nil {
+		return err
+	}
+	columns, values := copyValues(ctx, s, v)
+	if _, err := conn.CopyFrom(ctx, pgx.Identifier{s.Table}, columns, pgx.CopyFromRows(values)); err != nil {
+		return fmt.Errorf("%s: %w", s.Table, err)
+	}
+	return nil
+}
+
+func (exporter *PostgresExporter) schemaOf(t reflect.Type) (*schema.Schema, error) {
+	for _, s := range exporter.schemas {
+		if s.ModelType == t {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no table for %s", t)
+}
+
+// copyValues returns the columns of the schema and the values of each row in the slice v.
+func copyValues(ctx context.Context, s *schema.Schema, v reflect.Value) ([]string, [][]interface{}) {
+	var fields []*schema.Field
+	var columns []string
+	for _, field := range s.Fields {
+		if field.DBName != "" {
+			fields = append(fields, field)
+			columns = append(columns, field.DBName)
+		}
+	}
+	values := make([][]interface{}, v.Len())
+	for i := range values {
+		row := make([]interface{}, len(fields))
+		for j, field := range fields {
+			row[j], _ = field.ValueOf(ctx, v.Index(i))
+		}
+		values[i] = row
+	}
+	return columns, values
+}
+
+// isTransientPostgresError returns whether writing may succeed when retried, e.g. after a lost connection.
+func isTransientPostgresError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "08", // Connection exception
+			"53", // Insufficient resources, e.g. too many connections
+			"57", // Operator intervention, e.g. the server is shutting down
+			"58": // System error
+			return true
+		}
+		// Serialization failure and deadlock
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || pgconn.SafeToRetry(err) || pgconn.Timeout(err) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
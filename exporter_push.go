@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPushSpoolDir      = "spool"
+	defaultPushBatchSize     = 500
+	defaultPushFlushInterval = 10 * time.Second
+	defaultPushMaxSpooled    = 10000
+	pushRequestTimeout       = 30 * time.Second
+)
+
+// PushExporter sends the results in batches to a collector. Batches are spooled to disk before they are
+// sent and only removed once the collector stored them, so nothing is lost while the collector is unreachable.
+type PushExporter struct {
+	sync.Mutex
+	url        string
+	token      string
+	node       string
+	spoolDir   string
+	batching   batchConfig
+	maxSpooled int
+	client     *http.Client
+	pending    resultBatch
+	writer     *batchWriter
+}
+
+// NewPushExporter pushes the results as the node with the given ID. It is configured with COLLECTOR_URL,
+// COLLECTOR_TOKEN, PUSH_SPOOL_DIR, PUSH_BATCH_SIZE, PUSH_FLUSH_INTERVAL and PUSH_MAX_SPOOLED_BATCHES.
+func NewPushExporter(node string) (*PushExporter, error) {
+	collectorURL := os.Getenv("COLLECTOR_URL")
+	if collectorURL == "" {
+		return nil, errors.New("COLLECTOR_URL is required to push results")
+	}
+
+	exporter := &PushExporter{
+		url:      strings.TrimSuffix(collectorURL, "/") + collectorBatchPath,
+		token:    os.Getenv("COLLECTOR_TOKEN"),
+		node:     node,
+		spoolDir: defaultPushSpoolDir,
+		client:   &http.Client{Timeout: pushRequestTimeout},
+	}
+	if spoolDir := os.Getenv("PUSH_SPOOL_DIR"); spoolDir != "" {
+		exporter.spoolDir = spoolDir
+	}
+	var err error
+	exporter.batching, err = batchConfigFromEnv("PUSH_", batchConfig{batchSize: defaultPushBatchSize, flushInterval: defaultPushFlushInterval})
+	if err != nil {
+		return nil, err
+	}
+	if exporter.maxSpooled, err = positiveIntFromEnv("PUSH_MAX_SPOOLED_BATCHES", defaultPushMaxSpooled); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(exporter.spoolDir, 0o755); err != nil {
+		return nil, err
+	}
+	// The batches stay spooled until the collector stored them, sending is always retried
+	exporter.writer = newBatchWriter(exporter.url, exporter.batching.flushInterval, exporter.flush,
+		func(error) bool { return true })
+	return exporter, nil
+}
+
+// InitDaily starts sending on the first call, there are no daily files to switch.
+func (exporter *PushExporter) InitDaily() error {
+	if exporter.writer.start() {
+		Log.Info("Pushing results to ", exporter.url, " as ", exporter.node)
+	}
+	return nil
+}
+
+// Close spools the pending results, they are sent after the next start.
+func (exporter *PushExporter) Close() error {
+	exporter.Lock()
+	err := exporter.spoolLocked()
+	exporter.Unlock()
+	exporter.writer.stop()
+	return err
+}
+
+func (exporter *PushExporter) WritePingResult(result PingResult) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.PingResults = append(exporter.pending.PingResults, result)
+	return exporter.flushIfFullLocked()
+}
+
+func (exporter *PushExporter) WriteIPPingResult(result IPPingResult) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.IPPingResults = append(exporter.pending.IPPingResults, result)
+	return exporter.flushIfFullLocked()
+}
+
+func (exporter *PushExporter) WritePathStatistic(statistic PathStatistics) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.PathStatistics = append(exporter.pending.PathStatistics, statistic)
+	return exporter.flushIfFullLocked()
+}
+
+func (exporter *PushExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	exporter.pending.OneWayDelayResults = append(exporter.pending.OneWayDelayResults, result)
+	return exporter.flushIfFullLocked()
+}
+
+func (exporter *PushExporter) flushIfFullLocked() error {
+	if exporter.pending.rows() < exporter.batching.batchSize {
+		return nil
+	}
+	return exporter.spoolLocked()
+}
+
+// spoolLocked writes the pending results as a new batch to the spool directory.
+func (exporter *PushExporter) spoolLocked() error {
+	if exporter.pending.rows() == 0 {
+		return nil
+	}
+	batch := exporter.pending
+	exporter.pending = resultBatch{}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	batch.BatchID = hex.EncodeToString(id)
+	batch.Node = exporter.node
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	// Named by creation time, so batches are sent in order
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), batch.BatchID)
+	tmp := filepath.Join(exporter.spoolDir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(exporter.spoolDir, name)); err != nil {
+		return err
+	}
+
+	exporter.writer.notify()
+	return exporter.trimSpool()
+}
+
+// trimSpool drops the oldest batches if the collector was unreachable for too long.
+func (exporter *PushExporter) trimSpool() error {
+	spooled, err := exporter.spooled()
+	if err != nil {
+		return err
+	}
+	for len(spooled) > exporter.maxSpooled {
+		Log.Warn("Spool is full, dropping batch ", spooled[0])
+		if err := os.Remove(spooled[0]); err != nil {
+			return err
+		}
+		spooled = spooled[1:]
+	}
+	return nil
+}
+
+// spooled returns the spooled batches, oldest first.
+func (exporter *PushExporter) spooled() ([]string, error) {
+	spooled, err := filepath.Glob(filepath.Join(exporter.spoolDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(spooled)
+	return spooled, nil
+}
+
+func (exporter *PushExporter) status() []exporterStatus {
+	exporter.Lock()
+	status := exporterStatus{Exporter: "push", Pending: exporter.pending.rows()}
+	exporter.Unlock()
+	spooled, _ := exporter.spooled()
+	status.Spooled = len(spooled)
+	return []exporterStatus{status}
+}
+
+// flush spools the pending results and sends the spooled batches.
+func (exporter *PushExporter) flush(ctx context.Context) error {
+	exporter.Lock()
+	err := exporter.spoolLocked()
+	exporter.Unlock()
+	if err != nil {
+		Log.Error("Failed to spool results: ", err)
+	}
+	return exporter.sendSpooled(ctx)
+}
+
+func (exporter *PushExporter) sendSpooled(ctx context.Context) error {
+	spooled, err := exporter.spooled()
+	if err != nil {
+		return err
+	}
+	for _, file := range spooled {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := exporter.send(ctx, data); err != nil {
+			var rejected *rejectedBatchError
+			if !errors.As(err, &rejected) {
+				return err
+			}
+			// Retrying won't help, keep it for inspection
+			Log.Error("Collector rejected batch ", file, ": ", err)
+			if err := os.Rename(file, file+".rejected"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rejectedBatchError struct {
+	status int
+	body   string
+}
+
+func (e *rejectedBatchError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.body)
+}
+
+func (exporter *PushExporter) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, exporter.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if exporter.token != "" {
+		req.Header.Set("Authorization", "Bearer "+exporter.token)
+	}
+
+	resp, err := exporter.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusRequestTimeout:
+		return &rejectedBatchError{status: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	default:
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+}
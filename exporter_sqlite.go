@@ -1,9 +1,10 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -13,27 +14,46 @@ import (
 )
 
 type SQLiteExporter struct {
-	DbPath              string
+	DbPath              string // Database of the current period
 	originalDbPath      string
 	db                  *gorm.DB
+	rotation            rotation
+	periodStart         time.Time
+	dbs                 map[string]*gorm.DB // Open databases, the current one and those receiving late results
+	dbMutex             sync.Mutex
+	openRetry           time.Time // Don't try to open the database of a new period again before
+	openBackoff         *backoff
 	scionPings          []PingResult
 	pathStatistics      []PathStatistics
 	ipPings             []IPPingResult
+	oneWayDelays        []OneWayDelayResult
 	scionMutex          sync.Mutex
 	ipMutex             sync.Mutex
 	pathStatisticsMutex sync.Mutex
+	oneWayDelayMutex    sync.Mutex
 	batchSize           int
+	archiver            *dbArchiver // Processes the previous databases after rotation, optional
+	rollups             *pingRollups
+	tuning              sqliteTuning
+	identity            NodeIdentity // Recorded in the metadata of the databases
+	remotesHash         string
+	stopCheckpoints     chan struct{}
 }
 
 func NewSQLiteExporter() *SQLiteExporter {
 	exporter := &SQLiteExporter{
-		batchSize: 1,
+		batchSize:   1,
+		dbs:         make(map[string]*gorm.DB),
+		openBackoff: newBackoff(),
+		rollups:     newPingRollups(),
+		tuning:      durabilityProfiles[durabilityBalanced],
 	}
 	sqlitePath := os.Getenv("EXPORTER_SQLITE_DB_PATH")
 	if sqlitePath == "" {
 		sqlitePath = "pingmetrics.db"
 	}
 	exporter.originalDbPath = sqlitePath
+	exporter.rotation, _ = newRotation(rotateDaily, time.UTC, "", sqlitePath)
 
 	sqliteBatchSize := os.Getenv("EXPORTER_SQLITE_DB_BATCH_SIZE")
 	if sqliteBatchSize != "" {
@@ -47,65 +67,291 @@ func NewSQLiteExporter() *SQLiteExporter {
 	return exporter
 }
 
+// configureFromEnv sets up the rotation with EXPORTER_SQLITE_ROTATION, EXPORTER_SQLITE_TIMEZONE and
+// EXPORTER_SQLITE_FILENAME, the durability and the archival of rotated databases.
+func (exporter *SQLiteExporter) configureFromEnv() error {
+	var err error
+	exporter.rotation, err = rotationFromEnv("EXPORTER_SQLITE_", exporter.originalDbPath)
+	if err != nil {
+		return err
+	}
+	exporter.tuning, err = sqliteTuningFromEnv()
+	if err != nil {
+		return err
+	}
+	exporter.archiver, err = newDbArchiverFromEnv()
+	return err
+}
+
+// InitDaily opens the database of the current period. Later periods are opened when the first result of
+// them is written.
 func (exporter *SQLiteExporter) InitDaily() error {
-	exporter.pathStatisticsMutex.Lock()
-	exporter.scionMutex.Lock()
-	exporter.ipMutex.Lock()
-	defer exporter.ipMutex.Unlock()
-	defer exporter.scionMutex.Unlock()
-	defer exporter.pathStatisticsMutex.Unlock()
+	exporter.dbMutex.Lock()
+	defer exporter.dbMutex.Unlock()
 
-	if exporter.db != nil {
-		err := exporter.Close()
-		if err != nil {
-			return err
+	now := time.Now()
+	db, err := exporter.openDb(exporter.rotation.path(now))
+	if err != nil {
+		return err
+	}
+	exporter.switchPeriod(db, now)
+
+	if exporter.stopCheckpoints == nil {
+		exporter.stopCheckpoints = make(chan struct{})
+		go exporter.checkpoints(exporter.stopCheckpoints)
+	}
+	return nil
+}
+
+// checkpoints checkpoints the open databases regularly, so the WAL doesn't grow between rotations. The
+// checkpoints are passive, a reader holding a snapshot doesn't hold up the writes to the database.
+func (exporter *SQLiteExporter) checkpoints(stop chan struct{}) {
+	ticker := time.NewTicker(exporter.tuning.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Checkpoint without the lock, writes to the other databases and rotations don't wait for it
+		exporter.dbMutex.Lock()
+		dbs := make(map[string]*gorm.DB, len(exporter.dbs))
+		for path, db := range exporter.dbs {
+			dbs[path] = db
+		}
+		exporter.dbMutex.Unlock()
+
+		for path, db := range dbs {
+			if err := checkpoint(db, checkpointPassive); err != nil {
+				// Closed by a rotation in the meantime
+				Log.Debug("Failed to checkpoint database ", path, ": ", err)
+			}
 		}
 	}
+}
 
-	exporter.DbPath = strings.ReplaceAll(exporter.originalDbPath, ".db", "_"+time.Now().UTC().Format("2006-01-02")+".db")
-	Log.Info("Connecting to database ", exporter.DbPath)
-	// Create the sqlite file if it's not available
-	if _, err := os.Stat(exporter.DbPath); err != nil {
-		if _, err = os.Create(exporter.DbPath); err != nil {
-			return err
+func (exporter *SQLiteExporter) status() []exporterStatus {
+	exporter.dbMutex.Lock()
+	status := exporterStatus{Exporter: "sqlite", File: exporter.DbPath}
+	exporter.dbMutex.Unlock()
+	for _, buffered := range []struct {
+		mutex *sync.Mutex
+		rows  func() int
+	}{
+		{&exporter.scionMutex, func() int { return len(exporter.scionPings) }},
+		{&exporter.ipMutex, func() int { return len(exporter.ipPings) }},
+		{&exporter.pathStatisticsMutex, func() int { return len(exporter.pathStatistics) }},
+		{&exporter.oneWayDelayMutex, func() int { return len(exporter.oneWayDelays) }},
+	} {
+		buffered.mutex.Lock()
+		status.Pending += buffered.rows()
+		buffered.mutex.Unlock()
+	}
+	return []exporterStatus{status}
+}
+
+// dbFor returns the database for results with timestamp t, and rotates to the next period if t is after
+// the current one. Late results are only written to the database of the previous period, older ones go
+// to the current database. Must be called with the dbMutex held.
+func (exporter *SQLiteExporter) dbFor(t time.Time) (*gorm.DB, error) {
+	path := exporter.rotation.path(t)
+	if db, ok := exporter.dbs[path]; ok {
+		return db, nil
+	}
+
+	start := exporter.rotation.start(t)
+	newPeriod := start.After(exporter.periodStart)
+	if !newPeriod && exporter.db != nil && start.Before(exporter.rotation.start(exporter.periodStart.Add(-time.Nanosecond))) {
+		// Its database may already be archived, reopening it would overwrite the archive later
+		Log.Warn("Result of ", t.Format(time.RFC3339), " is older than the previous period, writing it to ", exporter.DbPath)
+		return exporter.db, nil
+	}
+	if newPeriod && time.Now().Before(exporter.openRetry) {
+		return exporter.db, nil
+	}
+	db, err := exporter.openDb(path)
+	if err != nil {
+		if !newPeriod || exporter.db == nil {
+			return nil, err
+		}
+		// Keep writing to the current database, e.g. if the disk is full, rather than losing the results
+		delay := exporter.openBackoff.next()
+		exporter.openRetry = time.Now().Add(delay)
+		Log.Error("Failed to open database ", path, ", writing to ", exporter.DbPath, " and retrying in ", delay, ": ", err)
+		return exporter.db, nil
+	}
+	if !newPeriod {
+		// Late result of the previous period, closed with the next rotation
+		exporter.dbs[path] = db
+		return db, nil
+	}
+	exporter.switchPeriod(db, t)
+	return db, nil
+}
+
+// switchPeriod makes db the database of the period of t. Only the previous period stays open for late
+// results, the others are closed and handed to the archiver.
+func (exporter *SQLiteExporter) switchPeriod(db *gorm.DB, t time.Time) {
+	exporter.periodStart = exporter.rotation.start(t)
+	exporter.db = db
+	exporter.DbPath = exporter.rotation.path(t)
+	exporter.dbs[exporter.DbPath] = db
+	exporter.openRetry = time.Time{}
+	exporter.openBackoff = newBackoff()
+
+	previous := exporter.rotation.path(exporter.periodStart.Add(-time.Nanosecond))
+	open := []string{exporter.DbPath}
+	for path, db := range exporter.dbs {
+		if path == exporter.DbPath || path == previous {
+			open = append(open, path)
+			continue
+		}
+		// Leave no WAL behind for the archiver
+		if err := checkpoint(db, checkpointTruncate); err != nil {
+			Log.Debug("Failed to checkpoint database ", path, ": ", err)
 		}
+		closeDb(db)
+		delete(exporter.dbs, path)
 	}
+	Log.Info("Writing results to ", exporter.DbPath)
 
-	db, err := gorm.Open(sqlite.Open(exporter.DbPath), &gorm.Config{
+	if exporter.archiver != nil && exporter.rotation.period != rotateNever {
+		go exporter.archiver.process(exporter.rotation.glob(), open)
+	}
+}
+
+func (exporter *SQLiteExporter) openDb(path string) (*gorm.DB, error) {
+	db, err := openSQLiteDb(path, exporter.tuning)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMetadata(db, exporter.identity, exporter.remotesHash); err != nil {
+		closeDb(db)
+		return nil, err
+	}
+	return db, nil
+}
+
+func openSQLiteDb(dbPath string, tuning sqliteTuning) (*gorm.DB, error) {
+	Log.Info("Connecting to database ", dbPath)
+	// Create the sqlite file if it's not available, in the directory of the period for templates like {date}/{hour}.db
+	if _, err := os.Stat(dbPath); err != nil {
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	db, err := gorm.Open(sqlite.Open(tuning.dsn(dbPath)), &gorm.Config{
 		SkipDefaultTransaction: true,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = db.AutoMigrate(&PingResult{}, &PathStatistics{}, &IPPingResult{})
-	if err != nil {
-		return err
+	if _, err := migrateDb(db); err != nil {
+		closeDb(db)
+		return nil, err
 	}
 
 	sqlDb, err := db.DB()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// We mutex our selves, this ensures no locking in the driver level
 	sqlDb.SetMaxOpenConns(1)
 
-	_, err = sqlDb.Exec("PRAGMA synchronous=OFF")
-	if err != nil {
-		return err
+	Log.Info("Database connection established to file ", dbPath)
+	return db, nil
+}
+
+func (exporter *SQLiteExporter) Close() error {
+	rollupErr := exporter.writeRollups(exporter.rollups.flush())
+
+	exporter.dbMutex.Lock()
+	defer exporter.dbMutex.Unlock()
+	if exporter.stopCheckpoints != nil {
+		close(exporter.stopCheckpoints)
+		exporter.stopCheckpoints = nil
 	}
+	errs := []error{rollupErr}
+	for path, db := range exporter.dbs {
+		if err := checkpoint(db, checkpointTruncate); err != nil {
+			Log.Debug("Failed to checkpoint database ", path, ": ", err)
+		}
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+		errs = append(errs, err)
+		delete(exporter.dbs, path)
+	}
+	return errors.Join(errs...)
+}
+
+// timestamped results are written to the database of the period of their timestamp.
+type timestamped interface {
+	timestamp() time.Time
+}
 
-	Log.Info("Database connection established to file ", exporter.DbPath)
+func (r PingResult) timestamp() time.Time        { return r.PingTime }
+func (r PathStatistics) timestamp() time.Time    { return r.LookupTime }
+func (r IPPingResult) timestamp() time.Time      { return r.PingTime }
+func (r OneWayDelayResult) timestamp() time.Time { return r.ProbeTime }
 
-	exporter.db = db
+// createRows writes the rows, split into the databases of their periods.
+func createRows[T timestamped](exporter *SQLiteExporter, rows []T) error {
+	exporter.dbMutex.Lock()
+	defer exporter.dbMutex.Unlock()
+
+	for len(rows) > 0 {
+		start := exporter.rotation.start(rows[0].timestamp())
+		n := 1
+		for n < len(rows) && exporter.rotation.start(rows[n].timestamp()).Equal(start) {
+			n++
+		}
+		db, err := exporter.dbFor(rows[0].timestamp())
+		if err != nil {
+			return err
+		}
+		batch := rows[:n]
+		if err := db.Create(&batch).Error; err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
 	return nil
 }
 
-func (exporter *SQLiteExporter) Close() error {
-	sqlDB, _ := exporter.db.DB()
-	// Close
-	return sqlDB.Close()
+// writeRollups writes the buckets to the databases of the periods they start in.
+func (exporter *SQLiteExporter) writeRollups(buckets []*rollupBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	exporter.dbMutex.Lock()
+	defer exporter.dbMutex.Unlock()
+
+	byDb := make(map[*gorm.DB][]*rollupBucket)
+	for _, bucket := range buckets {
+		db, err := exporter.dbFor(bucket.start)
+		if err != nil {
+			return err
+		}
+		byDb[db] = append(byDb[db], bucket)
+	}
+	for db, buckets := range byDb {
+		if err := upsertRollups(db, buckets); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (exporter *SQLiteExporter) WritePathStatistic(statistic PathStatistics) error {
@@ -114,19 +360,15 @@ func (exporter *SQLiteExporter) WritePathStatistic(statistic PathStatistics) err
 	Log.Debugf("fingerprints: %s, paths: %s\n", statistic.Fingerprints, statistic.Paths)
 
 	if exporter.batchSize == 1 {
-		dbResult := exporter.db.Create(&statistic)
-		if dbResult.Error != nil {
-			return dbResult.Error
-		}
-		return nil
+		return createRows(exporter, []PathStatistics{statistic})
 	}
 
 	exporter.pathStatistics = append(exporter.pathStatistics, statistic)
 	if len(exporter.pathStatistics) >= exporter.batchSize {
-		dbResult := exporter.db.Create(&exporter.pathStatistics)
+		err := createRows(exporter, exporter.pathStatistics)
 		exporter.pathStatistics = nil // Clear the slice after flushing
-		if dbResult.Error != nil {
-			return dbResult.Error
+		if err != nil {
+			return err
 		}
 	}
 
@@ -139,20 +381,21 @@ func (exporter *SQLiteExporter) WritePingResult(result PingResult) error {
 	exporter.scionMutex.Lock()
 	defer exporter.scionMutex.Unlock()
 
+	// Rollups are updated as results come in, independent of the batching of the raw results
+	if err := exporter.writeRollups(exporter.rollups.add(result, exporter.rotation.location)); err != nil {
+		Log.Error("Failed to write ping rollups: ", err)
+	}
+
 	if exporter.batchSize == 1 {
-		dbResult := exporter.db.Create(&result)
-		if dbResult.Error != nil {
-			return dbResult.Error
-		}
-		return nil
+		return createRows(exporter, []PingResult{result})
 	}
 
 	exporter.scionPings = append(exporter.scionPings, result)
 	if len(exporter.scionPings) >= exporter.batchSize {
-		dbResult := exporter.db.Create(&exporter.scionPings)
+		err := createRows(exporter, exporter.scionPings)
 		exporter.scionPings = nil // Clear the slice after flushing
-		if dbResult.Error != nil {
-			return dbResult.Error
+		if err != nil {
+			return err
 		}
 	}
 
@@ -164,21 +407,36 @@ func (exporter *SQLiteExporter) WriteIPPingResult(result IPPingResult) error {
 	defer exporter.ipMutex.Unlock()
 
 	if exporter.batchSize == 1 {
-		dbResult := exporter.db.Create(&result)
-		if dbResult.Error != nil {
-			return dbResult.Error
-		}
-		return nil
+		return createRows(exporter, []IPPingResult{result})
 	}
 
 	exporter.ipPings = append(exporter.ipPings, result)
 	if len(exporter.ipPings) >= exporter.batchSize {
-		dbResult := exporter.db.Create(&exporter.ipPings)
-		if dbResult.Error != nil {
-			return dbResult.Error
+		if err := createRows(exporter, exporter.ipPings); err != nil {
+			return err
 		}
 		exporter.ipPings = nil
 	}
 
 	return nil
 }
+
+func (exporter *SQLiteExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	exporter.oneWayDelayMutex.Lock()
+	defer exporter.oneWayDelayMutex.Unlock()
+
+	if exporter.batchSize == 1 {
+		return createRows(exporter, []OneWayDelayResult{result})
+	}
+
+	exporter.oneWayDelays = append(exporter.oneWayDelays, result)
+	if len(exporter.oneWayDelays) >= exporter.batchSize {
+		err := createRows(exporter, exporter.oneWayDelays)
+		exporter.oneWayDelays = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,18 +2,19 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 func TestSQLiteExporter_Init(t *testing.T) {
 	exporter := NewSQLiteExporter()
-	exporter.DbPath = "test_pingmetrics.db"
-	defer os.Remove(exporter.DbPath) // Clean up test database after the test
+	exporter.rotation, _ = newRotation(rotateDaily, time.UTC, "", filepath.Join(t.TempDir(), "pingmetrics.db"))
 
 	if err := exporter.InitDaily(); err != nil {
 		t.Fatalf("Failed to initialize SQLiteExporter: %v", err)
 	}
+	defer exporter.Close()
 
 	if _, err := os.Stat(exporter.DbPath); os.IsNotExist(err) {
 		t.Errorf("Expected database file %s to be created, but it does not exist", exporter.DbPath)
@@ -22,12 +23,12 @@ func TestSQLiteExporter_Init(t *testing.T) {
 
 func TestSQLiteExporter_WritePingResult(t *testing.T) {
 	exporter := NewSQLiteExporter()
-	exporter.DbPath = "test_pingmetrics.db"
-	defer os.Remove(exporter.DbPath) // Clean up test database after the test
+	exporter.rotation, _ = newRotation(rotateDaily, time.UTC, "", filepath.Join(t.TempDir(), "pingmetrics.db"))
 
 	if err := exporter.InitDaily(); err != nil {
 		t.Fatalf("Failed to initialize SQLiteExporter: %v", err)
 	}
+	defer exporter.Close()
 
 	pingResult := PingResult{
 		SrcSCIONAddr:    "1-ff00:0:110",
@@ -55,12 +56,12 @@ func TestSQLiteExporter_WritePingResult(t *testing.T) {
 
 func TestSQLiteExporter_WritePathStatistic(t *testing.T) {
 	exporter := NewSQLiteExporter()
-	exporter.DbPath = "test_pingmetrics.db"
-	defer os.Remove(exporter.DbPath) // Clean up test database after the test
+	exporter.rotation, _ = newRotation(rotateDaily, time.UTC, "", filepath.Join(t.TempDir(), "pingmetrics.db"))
 
 	if err := exporter.InitDaily(); err != nil {
 		t.Fatalf("Failed to initialize SQLiteExporter: %v", err)
 	}
+	defer exporter.Close()
 
 	pathStatistic := PathStatistics{
 		SrcSCIONAddr: "1-ff00:0:110",
@@ -86,3 +87,65 @@ func TestSQLiteExporter_WritePathStatistic(t *testing.T) {
 		t.Errorf("Expected MinRTT %v, got %v", pathStatistic.MinRTT, fetched.MinRTT)
 	}
 }
+
+func TestSQLiteExporter_ReadWhileWriting(t *testing.T) {
+	exporter := NewSQLiteExporter()
+	exporter.rotation, _ = newRotation(rotateDaily, time.UTC, "", filepath.Join(t.TempDir(), "pingmetrics.db"))
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatalf("Failed to initialize SQLiteExporter: %v", err)
+	}
+	defer exporter.Close()
+
+	var journalMode string
+	exporter.db.Raw("PRAGMA journal_mode").Scan(&journalMode)
+	if journalMode != "wal" {
+		t.Errorf("Expected WAL mode, got %s", journalMode)
+	}
+
+	reader, err := openSQLiteReadOnly(exporter.DbPath, exporter.tuning.busyTimeout)
+	if err != nil {
+		t.Fatalf("Failed to open database read-only: %v", err)
+	}
+	defer closeDb(reader)
+
+	// The reader keeps a snapshot open while the exporter writes
+	tx := reader.Begin()
+	var count int64
+	tx.Model(&PingResult{}).Count(&count)
+	if err := exporter.WritePingResult(PingResult{DstSCIONAddr: "1-ff00:0:111", PingTime: time.Now()}); err != nil {
+		t.Fatalf("Failed to write while reading: %v", err)
+	}
+	start := time.Now()
+	if err := checkpoint(exporter.db, checkpointPassive); err != nil {
+		t.Errorf("Failed to checkpoint while reading: %v", err)
+	}
+	if time.Since(start) >= exporter.tuning.busyTimeout {
+		t.Error("Expected the periodic checkpoint not to wait for the reader")
+	}
+	tx.Rollback()
+
+	reader.Model(&PingResult{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 row, got %d", count)
+	}
+	if err := reader.Create(&PingResult{}).Error; err == nil {
+		t.Error("Expected writing to the read-only connection to fail")
+	}
+
+	// db query attaches the live database read-only as well
+	query, err := openMergeDb(filepath.Join(t.TempDir(), "query.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDb(query)
+	if rows, err := mergeDb(query, exporter.DbPath, ""); err != nil || rows != 1 {
+		t.Errorf("Expected to read 1 row of the live database, got %d: %v", rows, err)
+	}
+	if err := attachSource(query, exporter.DbPath); err != nil {
+		t.Fatal(err)
+	}
+	defer query.Exec("DETACH DATABASE src")
+	if err := query.Exec("DELETE FROM src.ping_results").Error; err == nil {
+		t.Error("Expected the attached live database to be read-only")
+	}
+}
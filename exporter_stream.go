@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamFormatJSONL = "jsonl"
+	streamFormatCSV   = "csv"
+)
+
+// Columns of the CSV files, and keys of the JSON lines. Results have the columns of their type, the others are empty.
+// Append new columns at the end, the order is part of the documented format.
+var streamColumns = []string{"type", "time", "node_id", "node_site", "node_tags", "mesh_id", "src", "dst",
+	"probe_type", "fingerprint", "success", "rtt", "successful_pings", "max_pings", "local_stack_down",
+	"min_rtt", "max_rtt", "min_hops", "max_hops", "active_paths", "probed_paths", "available_paths", "paths",
+	"fingerprints", "forward_delay", "reverse_delay", "clock_offset", "sync_quality", "sync_error"}
+
+type streamField struct {
+	key   string
+	value interface{}
+}
+
+// StreamExporter appends every result as a JSON line or a CSV row to rotating files or to stdout, e.g. to be
+// picked up by journald or vector.
+type StreamExporter struct {
+	sync.Mutex
+	format      string
+	rotation    rotation
+	stdout      io.Writer // Instead of files, if set
+	files       map[string]*os.File
+	current     string
+	periodStart time.Time
+	header      bool // Whether the CSV header was written to stdout
+}
+
+// NewStreamExporter creates the exporter of the format, configured with EXPORTER_JSONL_PATH or EXPORTER_CSV_PATH,
+// "-" for stdout, and the rotation of the files with EXPORTER_JSONL_ROTATION, EXPORTER_JSONL_TIMEZONE and
+// EXPORTER_JSONL_FILENAME, or those of CSV.
+func NewStreamExporter(format string) (*StreamExporter, error) {
+	prefix := "EXPORTER_JSONL_"
+	path := "pingmetrics.jsonl"
+	if format == streamFormatCSV {
+		prefix = "EXPORTER_CSV_"
+		path = "pingmetrics.csv"
+	}
+	if p := os.Getenv(prefix + "PATH"); p != "" {
+		path = p
+	}
+
+	exporter := &StreamExporter{format: format, files: make(map[string]*os.File)}
+	if path == "-" {
+		// main moved the logs to stderr, see streamsToStdout
+		exporter.stdout = os.Stdout
+		return exporter, nil
+	}
+	var err error
+	exporter.rotation, err = rotationFromEnv(prefix, path)
+	if err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
+
+// streamsToStdout returns whether one of the exporters of EXPORTER writes its records to stdout.
+func streamsToStdout() bool {
+	for _, name := range strings.Split(os.Getenv("EXPORTER"), ",") {
+		switch strings.TrimSpace(name) {
+		case streamFormatJSONL:
+			if os.Getenv("EXPORTER_JSONL_PATH") == "-" {
+				return true
+			}
+		case streamFormatCSV:
+			if os.Getenv("EXPORTER_CSV_PATH") == "-" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InitDaily opens the file of the current period, later periods are opened with their first result.
+func (exporter *StreamExporter) InitDaily() error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	if exporter.stdout != nil {
+		return nil
+	}
+	_, err := exporter.writerFor(time.Now())
+	return err
+}
+
+func (exporter *StreamExporter) Close() error {
+	exporter.Lock()
+	defer exporter.Unlock()
+	var errs []error
+	for path, f := range exporter.files {
+		errs = append(errs, f.Close())
+		delete(exporter.files, path)
+	}
+	exporter.current = ""
+	return errors.Join(errs...)
+}
+
+func (exporter *StreamExporter) status() []exporterStatus {
+	exporter.Lock()
+	defer exporter.Unlock()
+	return []exporterStatus{{Exporter: exporter.format, File: exporter.current}}
+}
+
+// writerFor returns the file for results with timestamp t. Only the file of the previous period stays open
+// for late results when the next period starts, older results go to the current file, as with the SQLite exporter.
+func (exporter *StreamExporter) writerFor(t time.Time) (io.Writer, error) {
+	if exporter.stdout != nil {
+		return exporter.stdout, nil
+	}
+	path := exporter.rotation.path(t)
+	if f, ok := exporter.files[path]; ok {
+		return f, nil
+	}
+	start := exporter.rotation.start(t)
+	if exporter.current != "" && start.Before(exporter.rotation.start(exporter.periodStart.Add(-time.Nanosecond))) {
+		Log.Warn("Result of ", t.Format(time.RFC3339), " is older than the previous period, writing it to ", exporter.current)
+		return exporter.files[exporter.current], nil
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if exporter.format == streamFormatCSV {
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			if _, err := f.Write(csvRow(streamColumns)); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	exporter.files[path] = f
+
+	if exporter.current == "" || start.After(exporter.periodStart) {
+		exporter.current, exporter.periodStart = path, start
+		previous := exporter.rotation.path(start.Add(-time.Nanosecond))
+		for p, open := range exporter.files {
+			if p != path && p != previous {
+				open.Close()
+				delete(exporter.files, p)
+			}
+		}
+		Log.Info("Writing ", exporter.format, " results to ", path)
+	}
+	return f, nil
+}
+
+func (exporter *StreamExporter) write(t time.Time, fields []streamField) error {
+	line, err := exporter.encode(fields)
+	if err != nil {
+		return err
+	}
+
+	exporter.Lock()
+	defer exporter.Unlock()
+	w, err := exporter.writerFor(t)
+	if err != nil {
+		return err
+	}
+	if exporter.stdout != nil && exporter.format == streamFormatCSV && !exporter.header {
+		if _, err := w.Write(csvRow(streamColumns)); err != nil {
+			return err
+		}
+		exporter.header = true
+	}
+	// One write per line, so readers following the file never see partial lines
+	_, err = w.Write(line)
+	return err
+}
+
+func (exporter *StreamExporter) encode(fields []streamField) ([]byte, error) {
+	if exporter.format == streamFormatCSV {
+		row := make([]string, len(streamColumns))
+		for _, field := range fields {
+			for i, column := range streamColumns {
+				if column == field.key {
+					row[i] = csvValue(field.value)
+				}
+			}
+		}
+		return csvRow(row), nil
+	}
+	line, err := encodeJSONFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// encodeJSONFields encodes the fields as a JSON object by hand, to keep the keys in order.
+func encodeJSONFields(fields []streamField) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func csvRow(values []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(values)
+	w.Flush()
+	return buf.Bytes()
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func nodeFields(nodeID, site, tags, mesh string) []streamField {
+	return []streamField{{"node_id", nodeID}, {"node_site", site}, {"node_tags", tags}, {"mesh_id", mesh}}
+}
+
+func pingFields(result PingResult) []streamField {
+	fields := []streamField{{"type", "ping"}, {"time", result.PingTime.UTC()}}
+	fields = append(fields, nodeFields(result.NodeID, result.NodeSite, result.NodeTags, result.MeshID)...)
+	fields = append(fields, []streamField{
+		{"src", result.SrcSCIONAddr},
+		{"dst", result.DstSCIONAddr},
+		{"probe_type", result.ProbeType},
+		{"fingerprint", result.Fingerprint},
+		{"success", result.Success},
+		{"rtt", result.RTT},
+		{"successful_pings", result.SuccessfulPings},
+		{"max_pings", result.MaxPings},
+		{"local_stack_down", result.LocalStackDown},
+	}...)
+	return fields
+}
+
+func ipPingFields(result IPPingResult) []streamField {
+	fields := []streamField{{"type", "ip_ping"}, {"time", result.PingTime.UTC()}}
+	fields = append(fields, nodeFields(result.NodeID, result.NodeSite, result.NodeTags, result.MeshID)...)
+	fields = append(fields, []streamField{
+		{"src", result.SrcAddr},
+		{"dst", result.DstAddr},
+		{"success", result.Success},
+		{"rtt", result.RTT},
+	}...)
+	return fields
+}
+
+func pathStatisticFields(statistic PathStatistics) []streamField {
+	fields := []streamField{{"type", "path_statistics"}, {"time", statistic.LookupTime.UTC()}}
+	fields = append(fields, nodeFields(statistic.NodeID, statistic.NodeSite, statistic.NodeTags, statistic.MeshID)...)
+	fields = append(fields, []streamField{
+		{"src", statistic.SrcSCIONAddr},
+		{"dst", statistic.DstSCIONAddr},
+		{"probe_type", statistic.ProbeType},
+		{"success", statistic.Success},
+		{"local_stack_down", statistic.LocalStackDown},
+		{"min_rtt", statistic.MinRTT},
+		{"max_rtt", statistic.MaxRTT},
+		{"min_hops", statistic.MinHops},
+		{"max_hops", statistic.MaxHops},
+		{"active_paths", statistic.ActivePaths},
+		{"probed_paths", statistic.ProbedPaths},
+		{"available_paths", statistic.AvailablePaths},
+		{"paths", statistic.Paths},
+		{"fingerprints", statistic.Fingerprints},
+	}...)
+	return fields
+}
+
+func oneWayDelayFields(result OneWayDelayResult) []streamField {
+	fields := []streamField{{"type", "one_way_delay"}, {"time", result.ProbeTime.UTC()}}
+	fields = append(fields, nodeFields(result.NodeID, result.NodeSite, result.NodeTags, result.MeshID)...)
+	fields = append(fields, []streamField{
+		{"src", result.SrcSCIONAddr},
+		{"dst", result.DstSCIONAddr},
+		{"fingerprint", result.Fingerprint},
+		{"forward_delay", result.ForwardDelay},
+		{"reverse_delay", result.ReverseDelay},
+		{"clock_offset", result.ClockOffset},
+		{"sync_quality", result.SyncQuality},
+		{"sync_error", result.SyncError},
+	}...)
+	return fields
+}
+
+func (exporter *StreamExporter) WritePingResult(result PingResult) error {
+	return exporter.write(result.PingTime, pingFields(result))
+}
+
+func (exporter *StreamExporter) WriteIPPingResult(result IPPingResult) error {
+	return exporter.write(result.PingTime, ipPingFields(result))
+}
+
+func (exporter *StreamExporter) WritePathStatistic(statistic PathStatistics) error {
+	return exporter.write(statistic.LookupTime, pathStatisticFields(statistic))
+}
+
+func (exporter *StreamExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	return exporter.write(result.ProbeTime, oneWayDelayFields(result))
+}
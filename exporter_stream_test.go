@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamExporterJSONLRotation(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EXPORTER_JSONL_PATH", filepath.Join(dir, "results.jsonl"))
+	t.Setenv("EXPORTER_JSONL_ROTATION", rotateHourly)
+	exporter, err := NewStreamExporter(streamFormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{hour.Add(59 * time.Minute), hour.Add(61 * time.Minute), hour.Add(59*time.Minute + 59*time.Second)} {
+		err := exporter.WritePingResult(PingResult{DstSCIONAddr: "1-ff00:0:111", Success: true, RTT: 12.5, PingTime: ts})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, expected := range map[string]int{"results_2024-05-01T10.jsonl": 2, "results_2024-05-01T11.jsonl": 1} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != expected {
+			t.Errorf("Expected %d lines in %s, got %d", expected, name, len(lines))
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["type"] != "ping" || record["rtt"] != 12.5 || record["dst"] != "1-ff00:0:111" {
+			t.Errorf("Unexpected record %s", lines[0])
+		}
+	}
+}
+
+func TestStreamExporterOldResultsToCurrentFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EXPORTER_JSONL_PATH", filepath.Join(dir, "results.jsonl"))
+	t.Setenv("EXPORTER_JSONL_ROTATION", rotateHourly)
+	exporter, err := NewStreamExporter(streamFormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	// The previous hour still gets its late results, older ones go to the current file
+	for _, ts := range []time.Time{hour.Add(2 * time.Hour), hour.Add(time.Hour), hour} {
+		if err := exporter.WritePingResult(PingResult{DstSCIONAddr: "1-ff00:0:111", PingTime: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, expected := range map[string]int{"results_2024-05-01T12.jsonl": 2, "results_2024-05-01T11.jsonl": 1} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != expected {
+			t.Errorf("Expected %d lines in %s, got %d", expected, name, len(lines))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "results_2024-05-01T10.jsonl")); !os.IsNotExist(err) {
+		t.Error("Expected no file for results older than the previous period")
+	}
+}
+
+func TestStreamExporterCSVStdout(t *testing.T) {
+	t.Setenv("EXPORTER_CSV_PATH", "-")
+	exporter, err := NewStreamExporter(streamFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	exporter.stdout = &out
+
+	pingTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	exporter.WriteIPPingResult(IPPingResult{SrcAddr: "10.0.0.1", DstAddr: "10.0.0.3", Success: true, RTT: 3, PingTime: pingTime})
+	exporter.WritePathStatistic(PathStatistics{DstSCIONAddr: "1-ff00:0:111", Paths: "1>2,3>4", ActivePaths: 2, LookupTime: pingTime})
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(streamColumns, ",") {
+		t.Fatalf("Expected a header and 2 rows, got %v", rows)
+	}
+	column := func(row []string, name string) string {
+		for i, c := range streamColumns {
+			if c == name {
+				return row[i]
+			}
+		}
+		return ""
+	}
+	if column(rows[1], "type") != "ip_ping" || column(rows[1], "time") != "2024-05-01T10:00:00Z" || column(rows[1], "rtt") != "3" {
+		t.Errorf("Unexpected row %v", rows[1])
+	}
+	if column(rows[2], "paths") != "1>2,3>4" || column(rows[2], "active_paths") != "2" || column(rows[2], "rtt") != "" {
+		t.Errorf("Unexpected row %v", rows[2])
+	}
+}
+
+func TestStreamExporterStdoutOnlyRecords(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, logOut := os.Stdout, Log.out
+	os.Stdout = w
+	SetLogOutput(w)
+	defer func() {
+		os.Stdout = stdout
+		SetLogOutput(logOut)
+	}()
+
+	t.Setenv("EXPORTER", "sqlite,jsonl")
+	t.Setenv("EXPORTER_JSONL_PATH", "-")
+	if !streamsToStdout() {
+		t.Fatal("Expected the jsonl exporter to write to stdout")
+	}
+	// As main does before logging
+	SetLogOutput(os.Stderr)
+	exporter, err := NewStreamExporter(streamFormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Log.Info("Logged while streaming")
+	exporter.WriteIPPingResult(IPPingResult{DstAddr: "10.0.0.3", Success: true, RTT: 3, PingTime: time.Now()})
+	Log.Error("Failed while streaming")
+	exporter.WriteIPPingResult(IPPingResult{DstAddr: "10.0.0.4", PingTime: time.Now()})
+	w.Close()
+
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records on stdout, got %q", out.String())
+	}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record["type"] != "ip_ping" {
+			t.Errorf("Expected only records on stdout, got %q", line)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// LinkFaultEvent is emitted when an interface is suspected to cause failing paths,
+// i.e. it is traversed by failing paths to one or more destinations but by no working path.
+type LinkFaultEvent struct {
+	Interface    snet.PathInterface
+	Score        float64  // fraction of probed paths through the interface that failed
+	FailingPaths int      // # of failing paths through the interface
+	TotalPaths   int      // # of probed paths through the interface
+	Destinations []string // destinations with failing paths through the interface
+	DetectedAt   time.Time
+}
+
+// FaultLocalizer correlates the probe results of all destinations to localize faulty links.
+// Paths to different destinations often share interfaces, so if several of them fail at
+// the same time the shared interfaces are the likely culprit (boolean network tomography):
+//   - 1. Every interface on a working path is considered good
+//   - 2. The remaining interfaces on failing paths are candidates
+//   - 3. Greedily pick the candidate that explains most of the unexplained failing paths
+//   - 4. Report it if enough of the paths through it failed
+type FaultLocalizer struct {
+	sync.Mutex
+	minScore        float64 // min fraction of failing paths through an interface to report it
+	minFailingPaths int     // min # of failing paths through an interface to report it
+	suspected       []LinkFaultEvent
+	OnSuspect       func(LinkFaultEvent)
+}
+
+// linkObservation collects the probed paths traversing a single interface.
+type linkObservation struct {
+	failing      map[string]bool // fingerprints of failing paths
+	total        int
+	destinations map[string]bool
+}
+
+func NewFaultLocalizer(minScore float64, minFailingPaths int) *FaultLocalizer {
+	return &FaultLocalizer{
+		minScore:        minScore,
+		minFailingPaths: minFailingPaths,
+	}
+}
+
+// Localize runs the correlation over the probe results of one ProbeAll round,
+// emits an event for each suspected link and returns them.
+func (fl *FaultLocalizer) Localize(result *PathProbeResult) []LinkFaultEvent {
+	links := make(map[snet.PathInterface]*linkObservation)
+	good := make(map[snet.PathInterface]bool)
+	unexplained := make(map[string]bool)
+
+	for dest, destResult := range result.Destinations {
+		if destResult == nil {
+			continue
+		}
+		for _, path := range destResult.Paths {
+			failed := isFailedPathState(path.State)
+			if failed {
+				unexplained[path.Fingerprint] = true
+			}
+			for iface := range pathInterfaceSet(path.Path) {
+				if !failed {
+					good[iface] = true
+				}
+				link, ok := links[iface]
+				if !ok {
+					link = &linkObservation{
+						failing:      make(map[string]bool),
+						destinations: make(map[string]bool),
+					}
+					links[iface] = link
+				}
+				link.total++
+				if failed {
+					link.failing[path.Fingerprint] = true
+					link.destinations[dest] = true
+				}
+			}
+		}
+	}
+
+	// Deterministic order, so ties are always broken the same way
+	candidates := make([]snet.PathInterface, 0)
+	for iface, link := range links {
+		if !good[iface] && len(link.failing) > 0 {
+			candidates = append(candidates, iface)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].String() < candidates[j].String()
+	})
+
+	detectedAt := time.Now().UTC()
+	events := make([]LinkFaultEvent, 0)
+	for len(unexplained) > 0 {
+		var best snet.PathInterface
+		bestExplained := 0
+		for _, iface := range candidates {
+			explained := 0
+			for fp := range links[iface].failing {
+				if unexplained[fp] {
+					explained++
+				}
+			}
+			if explained > bestExplained {
+				best = iface
+				bestExplained = explained
+			}
+		}
+		if bestExplained == 0 {
+			break
+		}
+
+		link := links[best]
+		for fp := range link.failing {
+			delete(unexplained, fp)
+		}
+
+		score := float64(len(link.failing)) / float64(link.total)
+		if score < fl.minScore || len(link.failing) < fl.minFailingPaths {
+			continue
+		}
+
+		destinations := make([]string, 0, len(link.destinations))
+		for dest := range link.destinations {
+			destinations = append(destinations, dest)
+		}
+		sort.Strings(destinations)
+
+		events = append(events, LinkFaultEvent{
+			Interface:    best,
+			Score:        score,
+			FailingPaths: len(link.failing),
+			TotalPaths:   link.total,
+			Destinations: destinations,
+			DetectedAt:   detectedAt,
+		})
+	}
+
+	fl.Lock()
+	fl.suspected = events
+	fl.Unlock()
+
+	if fl.OnSuspect != nil {
+		for _, event := range events {
+			fl.OnSuspect(event)
+		}
+	}
+
+	return events
+}
+
+// Suspected returns the links suspected in the latest round.
+func (fl *FaultLocalizer) Suspected() []LinkFaultEvent {
+	fl.Lock()
+	defer fl.Unlock()
+	return append([]LinkFaultEvent(nil), fl.suspected...)
+}
+
+// pathInterfaceSet returns the set of interfaces traversed by the path.
+func pathInterfaceSet(path snet.Path) map[snet.PathInterface]bool {
+	interfaceSet := make(map[snet.PathInterface]bool)
+	if path == nil || path.Metadata() == nil {
+		return interfaceSet
+	}
+	for _, iface := range path.Metadata().Interfaces {
+		interfaceSet[iface] = true
+	}
+	return interfaceSet
+}
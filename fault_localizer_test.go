@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/private/common"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/pkg/snet/path"
+)
+
+func mustParseIA(s string) addr.IA {
+	ia, err := addr.ParseIA(s)
+	if err != nil {
+		panic(err)
+	}
+	return ia
+}
+
+func testPathStatus(state int, fingerprint string, hops ...string) PathStatus {
+	interfaces := make([]snet.PathInterface, 0)
+	for i, hop := range hops {
+		interfaces = append(interfaces, snet.PathInterface{
+			IA: mustParseIA(hop),
+			ID: common.IFIDType(i + 1),
+		})
+	}
+	return PathStatus{
+		State:       state,
+		Fingerprint: fingerprint,
+		Path:        path.Path{Meta: snet.PathMetadata{Interfaces: interfaces}},
+	}
+}
+
+func TestFaultLocalizer_SharedInterface(t *testing.T) {
+	// Both failing paths share 1-ff00:0:120#2, the working path only shares 1-ff00:0:110#1
+	result := &PathProbeResult{
+		Destinations: map[string]*DestinationProbeResult{
+			"1-ff00:0:130,10.0.0.1:30041": {Paths: []PathStatus{
+				testPathStatus(PATH_STATE_TIMEOUT, "a", "1-ff00:0:110", "1-ff00:0:120", "1-ff00:0:130"),
+			}},
+			"1-ff00:0:140,10.0.0.1:30041": {Paths: []PathStatus{
+				testPathStatus(PATH_STATE_DOWN, "b", "1-ff00:0:110", "1-ff00:0:120", "1-ff00:0:140"),
+			}},
+			"1-ff00:0:150,10.0.0.1:30041": {Paths: []PathStatus{
+				testPathStatus(PATH_STATE_PROBED, "c", "1-ff00:0:110", "1-ff00:0:150"),
+			}},
+		},
+	}
+
+	fl := NewFaultLocalizer(0.5, 2)
+	events := fl.Localize(result)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 suspected link, got %d: %v", len(events), events)
+	}
+
+	expected := snet.PathInterface{IA: mustParseIA("1-ff00:0:120"), ID: 2}
+	if events[0].Interface != expected {
+		t.Errorf("Expected suspected link %s, got %s", expected, events[0].Interface)
+	}
+	if events[0].FailingPaths != 2 || events[0].Score != 1 {
+		t.Errorf("Expected 2 failing paths with score 1, got %d with score %v", events[0].FailingPaths, events[0].Score)
+	}
+	if len(events[0].Destinations) != 2 {
+		t.Errorf("Expected 2 affected destinations, got %v", events[0].Destinations)
+	}
+}
+
+func TestFaultLocalizer_NoFailures(t *testing.T) {
+	result := &PathProbeResult{
+		Destinations: map[string]*DestinationProbeResult{
+			"1-ff00:0:130,10.0.0.1:30041": {Paths: []PathStatus{
+				testPathStatus(PATH_STATE_PROBED, "a", "1-ff00:0:110", "1-ff00:0:130"),
+			}},
+		},
+	}
+
+	fl := NewFaultLocalizer(0.5, 1)
+	if events := fl.Localize(result); len(events) != 0 {
+		t.Errorf("Expected no suspected links, got %v", events)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	localStackCheckInterval = 5 * time.Second
+	minReconnectBackoff     = 1 * time.Second
+	maxReconnectBackoff     = 1 * time.Minute
+)
+
+// backoff computes exponentially growing wait times between reconnection attempts.
+type backoff struct {
+	current time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+func newBackoff() *backoff {
+	return &backoff{min: minReconnectBackoff, max: maxReconnectBackoff}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+		return b.current
+	}
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+// localStackMonitor checks the connections to the SCION daemon and the pinger sockets and reconnects
+// with exponential backoff if they break, e.g. when the daemon or dispatcher on the box is restarted.
+// While the local stack is unavailable, the prober records this explicitly instead of remote path failures.
+type localStackMonitor struct {
+	prober     *PathProber
+	connect    func() error // Reconnects to the local SCION stack
+	newBackoff func() *backoff
+}
+
+func newLocalStackMonitor(prober *PathProber) *localStackMonitor {
+	return &localStackMonitor{prober: prober, connect: prober.reconnect, newBackoff: newBackoff}
+}
+
+func (m *localStackMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(localStackCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := m.check()
+			if err == nil {
+				continue
+			}
+			Log.Error("Local SCION stack unavailable: ", err)
+			m.prober.setLocalStackAvailable(false)
+			m.reconnect(ctx)
+		}
+	}
+}
+
+// check returns an error if the SCION daemon doesn't answer or a pinger socket is broken.
+func (m *localStackMonitor) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	defer cancel()
+	if _, err := host().sciond.LocalIA(ctx); err != nil {
+		return fmt.Errorf("SCION daemon: %w", err)
+	}
+
+	pool := m.prober.getPingerPool()
+	if pool == nil {
+		return fmt.Errorf("no SCION connections")
+	}
+	return pool.healthy()
+}
+
+// reconnect connects to the SCION daemon again and re-registers the pingers, until it succeeds.
+func (m *localStackMonitor) reconnect(ctx context.Context) {
+	b := m.newBackoff()
+	for {
+		err := m.connect()
+		if err == nil {
+			Log.Info("Reconnected to the local SCION stack")
+			m.prober.setLocalStackAvailable(true)
+			return
+		}
+		wait := b.next()
+		Log.Error("Failed to reconnect to the local SCION stack, retrying in ", wait, ": ", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/private/serrors"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+type testDaemon struct {
+	daemon.Connector
+	err error
+}
+
+func (d testDaemon) LocalIA(context.Context) (addr.IA, error) {
+	return mustParseIA("1-ff00:0:110"), d.err
+}
+
+func (d testDaemon) Close() error {
+	return nil
+}
+
+// testPacketConn returns the errors from ReadFrom, then blocks until it is closed.
+type testPacketConn struct {
+	snet.PacketConn
+	sync.Mutex
+	errs     []error
+	read     chan struct{} // Closed when all errors were returned
+	readOnce sync.Once
+	closed   chan struct{}
+}
+
+func newTestPacketConn(errs ...error) *testPacketConn {
+	return &testPacketConn{errs: errs, read: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (c *testPacketConn) ReadFrom(*snet.Packet, *net.UDPAddr) error {
+	c.Lock()
+	if len(c.errs) == 0 {
+		c.Unlock()
+		c.readOnce.Do(func() { close(c.read) })
+		<-c.closed
+		return net.ErrClosed
+	}
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	c.Unlock()
+	return err
+}
+
+func (c *testPacketConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// testDrainedPinger returns a pinger that read the errors from its connection.
+func testDrainedPinger(t *testing.T, errs ...error) *pinger {
+	conn := newTestPacketConn(errs...)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pinger{id: 1, conn: conn, replies: make(chan reply), cancel: cancel}
+	go p.drain(ctx)
+	t.Cleanup(func() { p.Close() })
+	select {
+	case <-conn.read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout reading from the connection")
+	}
+	return p
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: time.Second, max: 5 * time.Second}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if wait := b.next(); wait != expected {
+			t.Errorf("Attempt %d: expected %s, got %s", i, expected, wait)
+		}
+	}
+}
+
+func TestPingerHealthy(t *testing.T) {
+	socketErr := serrors.WrapStr("Reliable socket read error", &net.OpError{Op: "read", Err: errors.New("connection reset")})
+	packetErr := serrors.WrapStr("decoding packet", errors.New("invalid header"))
+
+	p := testDrainedPinger(t, packetErr, packetErr, packetErr, packetErr)
+	if err := p.healthy(); err != nil {
+		t.Errorf("Expected undecodable packets not to break the pinger, got %v", err)
+	}
+
+	p = testDrainedPinger(t, socketErr, packetErr, socketErr, socketErr)
+	if err := p.healthy(); err == nil {
+		t.Error("Expected the pinger to be broken after socket errors")
+	}
+
+	p = testDrainedPinger(t, socketErr, socketErr, nil)
+	if err := p.healthy(); err != nil {
+		t.Errorf("Expected a successful read to reset the errors, got %v", err)
+	}
+}
+
+func TestLocalStackMonitor(t *testing.T) {
+	prober := NewPathProber(10, 3)
+	monitor := newLocalStackMonitor(prober)
+	setHost(hostContext{sciond: testDaemon{}})
+
+	if err := monitor.check(); err == nil {
+		t.Error("Expected an error without SCION connections")
+	}
+	broken := testDrainedPinger(t, net.ErrClosed, net.ErrClosed, net.ErrClosed)
+	prober.setPingerPool(&pingerPool{pingers: []*pinger{broken}})
+	if err := monitor.check(); err == nil {
+		t.Error("Expected an error with a broken pinger")
+	}
+	prober.setPingerPool(&pingerPool{pingers: []*pinger{testDrainedPinger(t)}})
+	if err := monitor.check(); err != nil {
+		t.Errorf("Expected the local stack to be healthy, got %v", err)
+	}
+	setHost(hostContext{sciond: testDaemon{err: errors.New("connection refused")}})
+	if err := monitor.check(); err == nil {
+		t.Error("Expected an error if the SCION daemon doesn't answer")
+	}
+
+	attempts := 0
+	monitor.connect = func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("dispatcher unavailable")
+		}
+		return nil
+	}
+	monitor.newBackoff = func() *backoff { return &backoff{min: time.Millisecond, max: time.Millisecond} }
+	prober.setLocalStackAvailable(false)
+	monitor.reconnect(context.Background())
+	if attempts != 3 || !prober.LocalStackAvailable() {
+		t.Errorf("Expected to be reconnected after 3 attempts, got %d attempts, available %v", attempts, prober.LocalStackAvailable())
+	}
+
+	// Gives up when stopped
+	monitor.connect = func() error { return errors.New("dispatcher unavailable") }
+	prober.setLocalStackAvailable(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	monitor.reconnect(ctx)
+	if prober.LocalStackAvailable() {
+		t.Error("Expected the local stack to stay unavailable")
+	}
+}
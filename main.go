@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"net/netip"
 	"os"
@@ -12,6 +11,7 @@ import (
 	"time"
 
 	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers"
 	"github.com/scionproto/scion/pkg/snet"
 	"golang.org/x/sync/errgroup"
 )
@@ -19,6 +19,10 @@ import (
 var versionString string
 
 func main() {
+	if streamsToStdout() {
+		// Before the first log line, stdout only holds the records
+		SetLogOutput(os.Stderr)
+	}
 	if versionString == "" {
 		Log.Error("Missing version string.\n", "Build with version string:\n"+
 			"`go build -o ./bin/ -ldflags \"-X main.versionString=$(git describe --tags --dirty --always)\" ./...`")
@@ -26,18 +30,31 @@ func main() {
 	}
 	Log.Info("Go multiping version: ", versionString)
 
+	if len(os.Args) > 1 && os.Args[1] == "responder" {
+		runResponderMode()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "collector" {
+		runCollectorMode()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		os.Exit(runDBCommand(os.Args[2:]))
+	}
+
 	dia := addr.MustIAFrom(addr.ISD(71), addr.AS(559))
 	dhost := net.UDPAddr{IP: net.ParseIP("10.10.0.1"), Port: 30041}
 	remote := snet.UDPAddr{IA: dia, Host: &dhost}
 	destIAs := []snet.UDPAddr{remote, {IA: addr.MustIAFrom(addr.ISD(71), addr.AS(8589934666)), Host: &dhost}}
 
-	hc, err := initHostContext()
-	if err != nil {
-		Log.Debug("Failed init: ", err)
-		os.Exit(1)
-	}
+	// Wait for the local SCION stack instead of exiting, e.g. if we are started before the daemon
+	hc := connectHostContext()
+	setHost(hc)
+	Log.Info("Connecting to the local SCION stack in ", scionConnectionMode(), " mode")
 	args := os.Args
 	ipDestinations := []string{}
+	scionDestinations := map[string]SCIONDestination{}
+	destinationNames := map[string]string{}
 
 	remotesFile := "remotes.json"
 	remotesEnv := os.Getenv("REMOTES_FILE")
@@ -45,6 +62,18 @@ func main() {
 		remotesFile = remotesEnv
 	}
 
+	// In mesh mode, every node probes all others of the shared peers list
+	meshPeers := meshPeersFile()
+	if meshPeers != "" {
+		remotesFile = meshPeers
+		if _, err := os.Stat(meshPeers); err != nil {
+			Log.Error("Error reading mesh peers file: ", err)
+			os.Exit(1)
+		}
+	}
+	mesh := ""
+	localIP := getSaddr(hc.hostInLocalAS)
+
 	// Check if remotesFile exist
 	if _, err := os.Stat(remotesFile); os.IsNotExist(err) {
 		if len(args) < 2 {
@@ -78,6 +107,11 @@ func main() {
 			os.Exit(1)
 		}
 
+		if meshPeers != "" {
+			mesh = meshID(remotes)
+			Log.Info("Running in mesh ", mesh, " with ", len(remotes.SCIONDestinations), " peers")
+		}
+
 		var destinationIAs []snet.UDPAddr
 		for _, dest := range remotes.SCIONDestinations {
 			dAddr, err := addr.ParseAddr(dest.Address)
@@ -85,19 +119,28 @@ func main() {
 				Log.Info("Invalid destination: ", dAddr, " error: ", err)
 				os.Exit(1)
 			}
-			if dAddr.IA == hc.ia {
+			if meshPeers != "" {
+				if isMeshSelf(dAddr, hc.ia, localIP) {
+					Log.Info("Not probing ourselves: ", dest.Address, " for ", dest.Name)
+					continue
+				}
+			} else if dAddr.IA == hc.ia {
 				Log.Debug("Not probing local AS: ", dAddr.IA)
 				continue
 			}
-			destinationIAs = append(destinationIAs, snet.UDPAddr{IA: dAddr.IA, Host: &net.UDPAddr{
+			destAddr := snet.UDPAddr{IA: dAddr.IA, Host: &net.UDPAddr{
 				IP:   dAddr.Host.IP().AsSlice(),
 				Port: 30041,
-			}})
+			}}
+			destinationIAs = append(destinationIAs, destAddr)
+			scionDestinations[destAddr.String()] = dest
+			destinationNames[destAddr.String()] = dest.Name
 			Log.Info("Added SCION destination: ", dest.Address, " for ", dest.Name)
 		}
 
 		for _, dest := range remotes.IPDestinations {
 			ipDestinations = append(ipDestinations, dest.Address)
+			destinationNames[dest.Address] = dest.Name
 			Log.Info("Added IP destination: ", dest.Address, " for ", dest.Name)
 		}
 		destIAs = destinationIAs
@@ -106,7 +149,29 @@ func main() {
 
 	// Path prober, e.g. probe up to 100 paths to each destination and ping up to 3 every second
 	prober := NewPathProber(100, 3)
+	prober.MeshID = mesh
+	identity, err := nodeIdentityFromEnv()
+	if err != nil {
+		Log.Error("Invalid node identity: ", err)
+		os.Exit(1)
+	}
+	Log.Info("Running as node ", identity.ID, " at site ", identity.Site, " with tags ", identity.TagString())
+	exporter, err := newExporterFromEnv(identity, remotesHash(remotesFile), destinationNames)
+	if err != nil {
+		Log.Error("Error creating exporter: ", err)
+		os.Exit(1)
+	}
+	prober.Exporter = exporter
 	prober.SetDestinations(destIAs)
+	for destAddr, dest := range scionDestinations {
+		if len(dest.ProbeTypes) == 0 {
+			continue
+		}
+		if err := prober.SetProbeTypes(destAddr, dest.ProbeTypes, dest.UDPPort); err != nil {
+			Log.Error("Invalid probe types: ", err)
+			os.Exit(1)
+		}
+	}
 
 	err = prober.InitAndLookup(hc)
 	if err != nil {
@@ -114,6 +179,27 @@ func main() {
 		os.Exit(1)
 		return
 	}
+
+	Log.Info("Starting local SCION stack monitor...")
+	go newLocalStackMonitor(prober).run(context.Background())
+
+	serveStatusFromEnv(prober, identity, destinationNames)
+
+	// Reflect UDP probes of other multiping instances
+	if os.Getenv("RESPONDER_PORT") != "" {
+		config, err := responderConfigFromEnv()
+		if err != nil {
+			Log.Error("Invalid responder configuration: ", err)
+			os.Exit(1)
+		}
+		responder, err := newUDPResponder(context.Background(), hc.ia, net.UDPAddr{IP: getSaddr(hc.hostInLocalAS), Port: config.Port}, config)
+		if err != nil {
+			Log.Error("Error starting UDP responder: ", err)
+			os.Exit(1)
+		}
+		Log.Info("Started UDP responder on ", responder.local)
+		go responder.run(context.Background())
+	}
 	Log.Info("Starting prober...")
 	// Initial probing
 	_, err = prober.ProbeAll()
@@ -184,9 +270,6 @@ func main() {
 	// Ping IP destinations
 	go pingIPDestinations(prober, ipDestinations)
 
-	Log.Info("Starting cron to write daily databases...")
-	go dailyDatabaseUpdate(prober)
-
 	Log.Info("Gathering results...")
 
 	// Create a channel to receive OS signals
@@ -201,7 +284,7 @@ func main() {
 	// Goroutine to handle signals
 	go func() {
 		sig := <-signalChannel
-		fmt.Printf("Received signal: %s\n", sig)
+		Log.Info("Received signal: ", sig)
 		err := prober.Exporter.Close()
 		if err != nil {
 			Log.Error("Failed to close database connection ", err)
@@ -209,47 +292,12 @@ func main() {
 		done <- true
 	}()
 
-	fmt.Println("Press Ctrl+C to exit...")
+	Log.Info("Press Ctrl+C to exit...")
 
 	// Wait for a signal to be received
 	<-done
 
-	fmt.Println("Exiting...")
-}
-
-func dailyDatabaseUpdate(prober *PathProber) {
-	// Calculate the time until 12 AM
-	now := time.Now()
-	nextRun := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.FixedZone("UTC", 0)).Add(24 * time.Hour)
-	durationUntilNextRun := time.Until(nextRun)
-
-	// Wait until 12 AM
-	ms := durationUntilNextRun.Milliseconds()
-	Log.Infof("Waiting %s until the first run at 12 AM, this is %dms...\n", durationUntilNextRun, ms)
-	time.Sleep(time.Duration(ms * int64(time.Millisecond)))
-
-	// Start ticker to run the job every 24 hours
-	ticker := time.NewTicker(24 * time.Hour)
-	defer ticker.Stop()
-
-	for {
-		// Perform the task at 12 AM
-		changeDailyDatabase(prober)
-
-		// Wait for the next tick
-		<-ticker.C
-	}
-}
-
-func changeDailyDatabase(prober *PathProber) {
-	err := prober.Exporter.InitDaily()
-	if err != nil {
-		Log.Error("Failed to change database connection to new file ", err)
-		os.Exit(1)
-	}
-
-	Log.Info("Changed database connection to new file")
-
+	Log.Info("Exiting...")
 }
 
 func getDispatcherPath() string {
@@ -326,6 +374,7 @@ func pingIPDestinations(prober *PathProber, destinations []string) error {
 							Success:  err == nil && success,
 							RTT:      float64(diff.Milliseconds()),
 							PingTime: time.Now().UTC(),
+							MeshID:   prober.MeshID,
 						}
 
 						err = prober.Exporter.WriteIPPingResult(result)
@@ -355,11 +404,25 @@ func getSaddr(dest net.IP) net.IP {
 }
 
 type Update struct {
-	Size     int
-	Source   snet.SCIONAddress
-	Sequence int
-	RTT      time.Duration
-	State    State
+	Size      int
+	Source    snet.SCIONAddress
+	Sequence  int
+	RTT       time.Duration
+	State     State
+	ProbeType string
+
+	// Set for UDP probes, the remote timestamps if the responder filled them in
+	Sent           time.Time
+	Received       time.Time
+	RemoteReceived time.Time
+	RemoteSent     time.Time
+
+	// Set for SCMP errors, i.e. where the path broke
+	SCMPTypeCode          slayers.SCMPTypeCode
+	ErrorIA               addr.IA // AS that reported the error
+	ErrorInterface        uint64  // Interface that is down, the egress interface for internal connectivity down
+	ErrorIngressInterface uint64  // Ingress interface for internal connectivity down
+	MTU                   uint16  // MTU for packet too big
 }
 
 type State int
@@ -368,8 +431,12 @@ const (
 	Success State = iota
 	AfterTimeout
 	Duplicate
-	PathDown
+	PathDown // SCMP external interface down
 	SCMPUnknown
+	InternalConnectivityDown
+	DestinationUnreachable
+	PacketTooBig
+	ParameterProblem
 )
 
 type Stats struct {
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+const defaultMeshID = "default"
+
+// meshPeersFile returns the shared peers list if we run in mesh mode, i.e. MESH_PEERS_FILE is set.
+// The peers list has the same format as the remotes file and is the same on every node.
+func meshPeersFile() string {
+	return os.Getenv("MESH_PEERS_FILE")
+}
+
+// meshID returns the ID the results of this mesh are tagged with, MESH_ID overrides the mesh_id of the peers list.
+func meshID(peers *Destinations) string {
+	if id := os.Getenv("MESH_ID"); id != "" {
+		return id
+	}
+	if peers.MeshID != "" {
+		return peers.MeshID
+	}
+	return defaultMeshID
+}
+
+// isMeshSelf returns true if the peer is this node. Several peers can share an AS, so the host has to match as
+// well, or be the loopback address that peers use which are alone in their AS.
+func isMeshSelf(peer addr.Addr, localIA addr.IA, localIP net.IP) bool {
+	if peer.IA != localIA {
+		return false
+	}
+	peerIP := peer.Host.IP()
+	return peerIP.IsLoopback() || net.IP(peerIP.AsSlice()).Equal(localIP)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+func TestIsMeshSelf(t *testing.T) {
+	localIA := mustParseIA("71-20965")
+	localIP := net.ParseIP("10.0.1.1")
+
+	tests := []struct {
+		peer string
+		self bool
+	}{
+		{"71-20965,10.0.1.1", true},
+		{"71-20965,10.0.3.1", false}, // Another site in the same AS
+		{"71-225,127.0.0.1", false},
+		{"71-20965,127.0.0.1", true},
+	}
+	for _, test := range tests {
+		peer, err := addr.ParseAddr(test.peer)
+		if err != nil {
+			t.Fatalf("Failed to parse peer %s: %v", test.peer, err)
+		}
+		if self := isMeshSelf(peer, localIA, localIP); self != test.self {
+			t.Errorf("Expected isMeshSelf(%s) to be %v", test.peer, test.self)
+		}
+	}
+}
+
+func TestMeshID(t *testing.T) {
+	t.Setenv("MESH_ID", "")
+	if id := meshID(&Destinations{}); id != defaultMeshID {
+		t.Errorf("Expected default mesh ID, got %s", id)
+	}
+	if id := meshID(&Destinations{MeshID: "scionlab"}); id != "scionlab" {
+		t.Errorf("Expected mesh ID of the peers list, got %s", id)
+	}
+	t.Setenv("MESH_ID", "override")
+	if id := meshID(&Destinations{MeshID: "scionlab"}); id != "override" {
+		t.Errorf("Expected MESH_ID to override the peers list, got %s", id)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// NodeIdentity labels the results of this node, the source address can change with DHCP or multi-homing.
+type NodeIdentity struct {
+	ID   string            // NODE_ID, default: hostname
+	Site string            // NODE_SITE
+	Tags map[string]string // NODE_TAGS, e.g. "provider=geant,location=paris,flavor=anapaya"
+}
+
+func nodeIdentityFromEnv() (NodeIdentity, error) {
+	identity := NodeIdentity{
+		ID:   os.Getenv("NODE_ID"),
+		Site: os.Getenv("NODE_SITE"),
+	}
+	if identity.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return identity, err
+		}
+		identity.ID = hostname
+	}
+	tags, err := parseNodeTags(os.Getenv("NODE_TAGS"))
+	if err != nil {
+		return identity, err
+	}
+	identity.Tags = tags
+	return identity, nil
+}
+
+func parseNodeTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(tag, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid node tag %q, expected key=value", tag)
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// TagString returns the tags as sorted "key=value" pairs, comma separated.
+func (n NodeIdentity) TagString() string {
+	tags := make([]string, 0, len(n.Tags))
+	for key, value := range n.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+// identityExporter labels every result with the node identity before passing it on.
+type identityExporter struct {
+	DataExporter
+	identity NodeIdentity
+	tags     string
+}
+
+func newIdentityExporter(exporter DataExporter, identity NodeIdentity) *identityExporter {
+	return &identityExporter{DataExporter: exporter, identity: identity, tags: identity.TagString()}
+}
+
+func (e *identityExporter) WritePingResult(result PingResult) error {
+	result.NodeID, result.NodeSite, result.NodeTags = e.identity.ID, e.identity.Site, e.tags
+	return e.DataExporter.WritePingResult(result)
+}
+
+func (e *identityExporter) WriteIPPingResult(result IPPingResult) error {
+	result.NodeID, result.NodeSite, result.NodeTags = e.identity.ID, e.identity.Site, e.tags
+	return e.DataExporter.WriteIPPingResult(result)
+}
+
+func (e *identityExporter) WritePathStatistic(statistic PathStatistics) error {
+	statistic.NodeID, statistic.NodeSite, statistic.NodeTags = e.identity.ID, e.identity.Site, e.tags
+	return e.DataExporter.WritePathStatistic(statistic)
+}
+
+func (e *identityExporter) WriteOneWayDelayResult(result OneWayDelayResult) error {
+	result.NodeID, result.NodeSite, result.NodeTags = e.identity.ID, e.identity.Site, e.tags
+	return e.DataExporter.WriteOneWayDelayResult(result)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// recordingExporter keeps the ping results written to it.
+type recordingExporter struct {
+	DataExporter
+	pingResults []PingResult
+}
+
+func (e *recordingExporter) WritePingResult(result PingResult) error {
+	e.pingResults = append(e.pingResults, result)
+	return nil
+}
+
+func TestIdentityExporter_LabelsResults(t *testing.T) {
+	tags, err := parseNodeTags("provider=geant, location=paris,flavor=anapaya")
+	if err != nil {
+		t.Fatalf("Failed to parse tags: %v", err)
+	}
+	recorder := &recordingExporter{}
+	exporter := newIdentityExporter(recorder, NodeIdentity{ID: "geant-paris-1", Site: "Paris", Tags: tags})
+
+	if err := exporter.WritePingResult(PingResult{DstSCIONAddr: "1-ff00:0:111"}); err != nil {
+		t.Fatalf("Failed to write ping result: %v", err)
+	}
+	result := recorder.pingResults[0]
+	if result.NodeID != "geant-paris-1" || result.NodeSite != "Paris" {
+		t.Errorf("Expected node geant-paris-1 at Paris, got %s at %s", result.NodeID, result.NodeSite)
+	}
+	if result.NodeTags != "flavor=anapaya,location=paris,provider=geant" {
+		t.Errorf("Expected sorted tags, got %s", result.NodeTags)
+	}
+
+	if _, err := parseNodeTags("provider"); err == nil {
+		t.Errorf("Expected error for tag without value")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe type measuring forward and reverse delay separately, using the timestamps of the responder
+const probeTypeOWD = "owd"
+
+const (
+	clockOffsetWindow   = 10 * time.Minute // Samples the min-filter picks the offset from
+	chronyCheckInterval = 1 * time.Minute
+	chronyTimeout       = 2 * time.Second
+)
+
+// How the clock offset to the remote was estimated
+const (
+	syncQualityChrony    = "chrony"    // Local chrony is synchronized, the remote clock is assumed to be synchronized too
+	syncQualityMinFilter = "minfilter" // Offset of the sample with the lowest RTT, assuming its delays are symmetric
+)
+
+// owdSample holds the timestamps of a probe reflected by a responder: sent by us (t1), received (t2) and
+// sent (t3) by the responder and received by us (t4). t2 and t3 are taken by the remote clock.
+type owdSample struct {
+	t1, t2, t3, t4 time.Time
+}
+
+func (s owdSample) valid() bool {
+	return !s.t1.IsZero() && !s.t2.IsZero() && !s.t3.IsZero() && !s.t4.IsZero()
+}
+
+// rtt returns the round trip time without the time spent in the responder.
+func (s owdSample) rtt() time.Duration {
+	return s.t4.Sub(s.t1) - s.t3.Sub(s.t2)
+}
+
+// offset returns the offset of the remote clock, if the forward and reverse delays of the sample were equal.
+func (s owdSample) offset() time.Duration {
+	return (s.t2.Sub(s.t1) + s.t3.Sub(s.t4)) / 2
+}
+
+type OneWayDelay struct {
+	Forward     time.Duration
+	Reverse     time.Duration
+	ClockOffset time.Duration // Estimated offset of the remote clock, added to local time
+	SyncQuality string
+	SyncError   time.Duration // Error bound of the offset estimation
+}
+
+// clockOffsetEstimator estimates the clock offset to each remote with a min-filter over recent samples of
+// all paths to it, the sample with the lowest RTT has the least queuing and thus bounds the offset best.
+// If the local clock is synchronized by chrony, the remote clocks are assumed to be synchronized as well and
+// the offset is taken as 0. The responder doesn't report its sync state, so this is not verified and the
+// error bound only covers the local clock.
+type clockOffsetEstimator struct {
+	sync.Mutex
+	window  time.Duration
+	samples map[string][]owdSample
+	chrony  chronyTracker
+}
+
+func newClockOffsetEstimator() *clockOffsetEstimator {
+	return &clockOffsetEstimator{
+		window:  clockOffsetWindow,
+		samples: make(map[string][]owdSample),
+		chrony:  chronyTracker{track: chronyTracking},
+	}
+}
+
+// estimate adds the sample and returns the forward and reverse delay of it.
+func (e *clockOffsetEstimator) estimate(remote string, sample owdSample) OneWayDelay {
+	offset, syncQuality, syncError := e.offset(remote, sample)
+	return OneWayDelay{
+		Forward:     sample.t2.Sub(sample.t1) - offset,
+		Reverse:     sample.t4.Sub(sample.t3) + offset,
+		ClockOffset: offset,
+		SyncQuality: syncQuality,
+		SyncError:   syncError,
+	}
+}
+
+func (e *clockOffsetEstimator) offset(remote string, sample owdSample) (time.Duration, string, time.Duration) {
+	// Checked before locking, running chronyc must not hold up the results of other probes
+	maxError, chronySynchronized := e.chrony.synchronized()
+
+	e.Lock()
+	defer e.Unlock()
+
+	samples := append(e.samples[remote], sample)
+	for len(samples) > 0 && sample.t1.Sub(samples[0].t1) > e.window {
+		samples = samples[1:]
+	}
+	e.samples[remote] = samples
+
+	if chronySynchronized {
+		return 0, syncQualityChrony, maxError
+	}
+
+	best := samples[0]
+	for _, s := range samples[1:] {
+		if s.rtt() < best.rtt() {
+			best = s
+		}
+	}
+	// The offset is off by at most half the RTT, if all delay was in one direction
+	return best.offset(), syncQualityMinFilter, best.rtt() / 2
+}
+
+// chronyTracker caches whether the local clock is synchronized by chrony.
+type chronyTracker struct {
+	sync.Mutex
+	track     func() (chronyStatus, error)
+	checked   time.Time
+	status    chronyStatus
+	available bool
+}
+
+type chronyStatus struct {
+	SystemOffset   time.Duration
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	LeapStatus     string
+}
+
+// synchronized returns the maximum error of the local clock if it is synchronized by chrony.
+// Only one caller refreshes the status, the others use the previous one meanwhile.
+func (c *chronyTracker) synchronized() (time.Duration, bool) {
+	c.Lock()
+	refresh := time.Since(c.checked) > chronyCheckInterval
+	if refresh {
+		c.checked = time.Now()
+	}
+	c.Unlock()
+
+	if refresh {
+		status, err := c.track()
+		if err != nil {
+			Log.Debug("Clock synchronization not available from chrony, falling back to the min-filter: ", err)
+		}
+		c.Lock()
+		c.status, c.available = status, err == nil
+		c.Unlock()
+	}
+
+	c.Lock()
+	status, available := c.status, c.available
+	c.Unlock()
+	if !available || status.LeapStatus != "Normal" {
+		return 0, false
+	}
+	offset := status.SystemOffset
+	if offset < 0 {
+		offset = -offset
+	}
+	return offset + status.RootDelay/2 + status.RootDispersion, true
+}
+
+func chronyTracking() (chronyStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), chronyTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "chronyc", "-c", "tracking").Output()
+	if err != nil {
+		return chronyStatus{}, err
+	}
+	return parseChronyTracking(string(out))
+}
+
+// parseChronyTracking parses the CSV output of `chronyc -c tracking`.
+func parseChronyTracking(out string) (chronyStatus, error) {
+	fields := strings.Split(strings.TrimSpace(out), ",")
+	if len(fields) < 14 {
+		return chronyStatus{}, fmt.Errorf("unexpected chronyc tracking output %q", out)
+	}
+	seconds := func(field string) (time.Duration, error) {
+		s, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected chronyc tracking output %q: %w", out, err)
+		}
+		return time.Duration(s * float64(time.Second)), nil
+	}
+
+	var status chronyStatus
+	var err error
+	if status.SystemOffset, err = seconds(fields[4]); err != nil {
+		return chronyStatus{}, err
+	}
+	if status.RootDelay, err = seconds(fields[10]); err != nil {
+		return chronyStatus{}, err
+	}
+	if status.RootDispersion, err = seconds(fields[11]); err != nil {
+		return chronyStatus{}, err
+	}
+	status.LeapStatus = fields[13]
+	return status, nil
+}
+
+// milliseconds returns the duration in fractional milliseconds, one-way delays are often below 1ms apart.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClockOffsetEstimator_MinFilter(t *testing.T) {
+	e := newClockOffsetEstimator()
+	e.chrony.track = func() (chronyStatus, error) { return chronyStatus{}, errors.New("chronyc not found") }
+
+	// Remote clock is 100ms ahead, forward delay 10ms and reverse delay 10ms
+	t1 := time.Unix(1700000000, 0)
+	offset := 100 * time.Millisecond
+	symmetric := owdSample{t1: t1, t2: t1.Add(10*time.Millisecond + offset), t3: t1.Add(11*time.Millisecond + offset), t4: t1.Add(21 * time.Millisecond)}
+	owd := e.estimate("1-ff00:0:111,10.0.0.1:30041", symmetric)
+	if owd.ClockOffset != offset || owd.SyncQuality != syncQualityMinFilter {
+		t.Errorf("Expected offset %v from the min-filter, got %v from %s", offset, owd.ClockOffset, owd.SyncQuality)
+	}
+
+	// Queuing on the forward path of a later probe must not change the offset
+	t1 = t1.Add(time.Second)
+	queued := owdSample{t1: t1, t2: t1.Add(30*time.Millisecond + offset), t3: t1.Add(31*time.Millisecond + offset), t4: t1.Add(41 * time.Millisecond)}
+	owd = e.estimate("1-ff00:0:111,10.0.0.1:30041", queued)
+	if owd.Forward != 30*time.Millisecond || owd.Reverse != 10*time.Millisecond {
+		t.Errorf("Expected forward 30ms and reverse 10ms, got %v and %v", owd.Forward, owd.Reverse)
+	}
+	if owd.SyncError != 10*time.Millisecond {
+		t.Errorf("Expected error bound of half the min RTT, got %v", owd.SyncError)
+	}
+}
+
+func TestClockOffsetEstimator_ChronyDoesNotBlock(t *testing.T) {
+	e := newClockOffsetEstimator()
+	tracking, unblock := make(chan struct{}), make(chan struct{})
+	e.chrony.track = func() (chronyStatus, error) {
+		close(tracking)
+		<-unblock
+		return chronyStatus{LeapStatus: "Normal"}, nil
+	}
+	defer close(unblock)
+
+	t1 := time.Unix(1700000000, 0)
+	sample := owdSample{t1: t1, t2: t1.Add(10 * time.Millisecond), t3: t1.Add(11 * time.Millisecond), t4: t1.Add(21 * time.Millisecond)}
+	go e.estimate("1-ff00:0:111,10.0.0.1:30041", sample)
+	<-tracking
+
+	// While chronyc is running, other results use the previous status
+	done := make(chan OneWayDelay)
+	go func() { done <- e.estimate("1-ff00:0:112,10.0.0.2:30041", sample) }()
+	select {
+	case owd := <-done:
+		if owd.SyncQuality != syncQualityMinFilter {
+			t.Errorf("Expected the min-filter before chrony was checked, got %s", owd.SyncQuality)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Estimate blocked while chronyc was running")
+	}
+}
+
+func TestParseChronyTracking(t *testing.T) {
+	out := "A29FC87B,162.159.200.123,4,1700000000.123456,-0.000012345,0.000001,0.000020,-5.123,0.001,0.050,0.012000,0.001500,64.2,Normal\n"
+	status, err := parseChronyTracking(out)
+	if err != nil {
+		t.Fatalf("Failed to parse chronyc output: %v", err)
+	}
+	if status.LeapStatus != "Normal" || status.RootDelay != 12*time.Millisecond || status.RootDispersion != 1500*time.Microsecond {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	if _, err := parseChronyTracking("506 Cannot talk to daemon"); err == nil {
+		t.Errorf("Expected error for unexpected output")
+	}
+}
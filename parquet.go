@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"gorm.io/gorm"
+)
+
+// Partition of rows without node ID, e.g. of databases of older versions without metadata
+const unknownNode = "unknown"
+
+// Rows read from the database and written to the Parquet files at once, a day has over a million ping results
+const parquetBatchSize = 10000
+
+// partitioned results are written to the Parquet files of the date of their timestamp and of their node.
+type partitioned interface {
+	timestamped
+	nodeID() string
+}
+
+func (r PingResult) nodeID() string        { return r.NodeID }
+func (r PathStatistics) nodeID() string    { return r.NodeID }
+func (r IPPingResult) nodeID() string      { return r.NodeID }
+func (r OneWayDelayResult) nodeID() string { return r.NodeID }
+
+// exportParquet writes the result tables of the database to Parquet files, partitioned by table, date and
+// node, e.g. dir/ping_results/date=2024-05-01/node=zurich-1/pingmetrics_2024-05-01.parquet. The files are
+// named like the database, so databases of other periods or nodes don't overwrite each other, and exporting
+// a database again replaces its files. It returns the written files.
+func exportParquet(path string, dir string) ([]string, error) {
+	db, err := openSQLiteReadOnly(path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDb(db)
+
+	// Rows of older versions without node ID belong to the node of the database
+	node, _ := getMetadata(db, metadataNodeID)
+	if node == "" {
+		node = unknownNode
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var files []string
+	var errs []error
+	add := func(written []string, err error) {
+		files = append(files, written...)
+		errs = append(errs, err)
+	}
+	add(exportParquetTable[PingResult](db, dir, "ping_results", "ping_time", name, node))
+	add(exportParquetTable[PathStatistics](db, dir, "path_statistics", "lookup_time", name, node))
+	add(exportParquetTable[IPPingResult](db, dir, "ip_ping_results", "ping_time", name, node))
+	add(exportParquetTable[OneWayDelayResult](db, dir, "one_way_delay_results", "probe_time", name, node))
+	return files, errors.Join(errs...)
+}
+
+// exportParquetTable streams the rows of the table in the order of their time column to the Parquet files of
+// their partition, in batches of parquetBatchSize. FindInBatches can't be used, it pages by primary key and
+// the result tables have none.
+func exportParquetTable[T partitioned](db *gorm.DB, dir string, table string, timeColumn string, name string, node string) ([]string, error) {
+	if !db.Migrator().HasTable(table) {
+		return nil, nil
+	}
+	rows, err := db.Table(table).Order(timeColumn).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]*parquetFile[T])
+	var paths []string
+	abort := func(err error) ([]string, error) {
+		for _, file := range files {
+			file.abort()
+		}
+		return nil, err
+	}
+
+	batch := make([]T, 0, parquetBatchSize)
+	write := func() error {
+		partitions := make(map[string][]T)
+		for _, row := range batch {
+			rowNode := row.nodeID()
+			if rowNode == "" {
+				rowNode = node
+			}
+			path := filepath.Join(dir, table, "date="+row.timestamp().UTC().Format(time.DateOnly),
+				"node="+partitionValue(rowNode), name+".parquet")
+			partitions[path] = append(partitions[path], row)
+		}
+		for path, partition := range partitions {
+			file, ok := files[path]
+			if !ok {
+				var err error
+				if file, err = createParquetFile[T](path); err != nil {
+					return err
+				}
+				files[path] = file
+				paths = append(paths, path)
+			}
+			if _, err := file.writer.Write(partition); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var row T
+		if err := db.ScanRows(rows, &row); err != nil {
+			return abort(err)
+		}
+		batch = append(batch, row)
+		if len(batch) == parquetBatchSize {
+			if err := write(); err != nil {
+				return abort(err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return abort(err)
+	}
+	if err := write(); err != nil {
+		return abort(err)
+	}
+
+	sort.Strings(paths)
+	for i, path := range paths {
+		if err := files[path].close(); err != nil {
+			for _, path := range paths[i+1:] {
+				files[path].abort()
+			}
+			return paths[:i], err
+		}
+	}
+	return paths, nil
+}
+
+// partitionValue escapes the characters that can't be in a directory name.
+func partitionValue(value string) string {
+	return strings.NewReplacer("/", "%2F", "\\", "%5C", "=", "%3D", "%", "%25").Replace(value)
+}
+
+// parquetFile is written to a temporary file first, which replaces the file once it is complete.
+type parquetFile[T any] struct {
+	path   string
+	file   *os.File
+	writer *parquet.GenericWriter[T]
+}
+
+func createParquetFile[T any](path string) (*parquetFile[T], error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	// Row groups are flushed once a batch is buffered, not only when closing
+	w := parquet.NewGenericWriter[T](f, parquet.Compression(&parquet.Zstd), parquet.MaxRowsPerRowGroup(parquetBatchSize))
+	return &parquetFile[T]{path: path, file: f, writer: w}, nil
+}
+
+func (p *parquetFile[T]) close() error {
+	defer p.abort()
+	if err := p.writer.Close(); err != nil {
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(p.file.Name(), p.path)
+}
+
+// abort removes the temporary file, if it wasn't renamed yet.
+func (p *parquetFile[T]) abort() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
+
+// parquetExported returns whether the database was exported to the directory after it was last written.
+func parquetExported(path string, dir string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".parquet"
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "date=*", "node=*", name))
+	if len(files) == 0 {
+		return false
+	}
+	for _, file := range files {
+		exported, err := os.Stat(file)
+		if err != nil || exported.ModTime().Before(info.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+func runDBParquet(args []string) error {
+	flags := flag.NewFlagSet("db parquet", flag.ContinueOnError)
+	output := flags.String("o", "parquet", "directory to write the Parquet files to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	files, err := expandDbFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no databases to export")
+	}
+
+	for _, file := range files {
+		written, err := exportParquet(file, *output)
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", file, err)
+		}
+		fmt.Printf("Exported %s to %d Parquet files\n", file, len(written))
+	}
+	return nil
+}
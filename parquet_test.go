@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExportParquet_PartitionsByDateAndNode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pingmetrics_2024-05-01.db")
+	db, err := openMergeDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setMetadata(db, "node_id", "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	late := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	rows := []PingResult{
+		{DstSCIONAddr: "1-ff00:0:111", RTT: 12.5, Success: true, PingTime: late},
+		{DstSCIONAddr: "1-ff00:0:112", PingTime: late.Add(2 * time.Minute), NodeID: "node-2"},
+		{DstSCIONAddr: "1-ff00:0:111", RTT: 13, Success: true, PingTime: late.Add(time.Second), NodeID: "node-2"},
+	}
+	if err := db.Create(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDb(db)
+
+	out := filepath.Join(dir, "parquet")
+	files, err := exportParquet(path, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{
+		"ping_results/date=2024-05-01/node=node-1/pingmetrics_2024-05-01.parquet": 1,
+		"ping_results/date=2024-05-01/node=node-2/pingmetrics_2024-05-01.parquet": 1,
+		"ping_results/date=2024-05-02/node=node-2/pingmetrics_2024-05-01.parquet": 1,
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %d files, got %v", len(expected), files)
+	}
+	for name, count := range expected {
+		exported, err := parquet.ReadFile[PingResult](filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(exported) != count {
+			t.Errorf("Expected %d rows in %s, got %d", count, name, len(exported))
+		}
+	}
+
+	exported, err := parquet.ReadFile[PingResult](filepath.Join(out, "ping_results/date=2024-05-01/node=node-2/pingmetrics_2024-05-01.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exported[0].PingTime.Equal(rows[2].PingTime) || exported[0].RTT != 13 || exported[0].DstSCIONAddr != "1-ff00:0:111" {
+		t.Errorf("Unexpected row %+v", exported[0])
+	}
+	if !parquetExported(path, out) {
+		t.Error("Expected the database to be exported")
+	}
+	schema := parquet.SchemaOf(PingResult{}).String()
+	if !strings.Contains(schema, "ping_time (TIMESTAMP(isAdjustedToUTC=true,unit=MICROS))") {
+		t.Errorf("Unexpected schema %s", schema)
+	}
+}
+
+func TestExportParquet_OldDatabase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pingmetrics_2024-05-01.db")
+	testOldDailyDb(t, path, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	out := filepath.Join(dir, "parquet")
+	files, err := exportParquet(path, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != filepath.Join(out, "ping_results/date=2024-05-01/node=unknown/pingmetrics_2024-05-01.parquet") {
+		t.Fatalf("Unexpected files %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(out, "ip_ping_results")); !os.IsNotExist(err) {
+		t.Error("Expected no files for missing tables")
+	}
+}
+
+func TestExportParquet_Batches(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pingmetrics_2024-05-01.db")
+	db, err := openMergeDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Inserted newest first, across the end of the day
+	start := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	rows := make([]PingResult, 2*parquetBatchSize+parquetBatchSize/2)
+	for i := range rows {
+		rows[i] = PingResult{DstSCIONAddr: "1-ff00:0:111", NodeID: "node-1", PingTime: start.Add(time.Duration(len(rows)-i) * time.Second)}
+	}
+	if err := db.CreateInBatches(&rows, 1000).Error; err != nil {
+		t.Fatal(err)
+	}
+	closeDb(db)
+
+	out := filepath.Join(dir, "parquet")
+	files, err := exportParquet(path, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected files of two days, got %v", files)
+	}
+	total := 0
+	for _, file := range files {
+		exported, err := parquet.ReadFile[PingResult](file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(exported); i++ {
+			if exported[i].PingTime.Before(exported[i-1].PingTime) {
+				t.Fatalf("Rows of %s not in time order at %d", file, i)
+			}
+		}
+		total += len(exported)
+	}
+	if total != len(rows) {
+		t.Errorf("Expected %d rows, got %d", len(rows), total)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/scionproto/scion/pkg/addr"
@@ -26,14 +28,64 @@ var pingPathSets PingPathSets
 
 // Remember path states for the pinging module to know which paths to ping, i.e. the "PATH_STATE_PING" paths.
 const (
-	PATH_STATE_PING    = iota // Used for the current ping interval
-	PATH_STATE_IDLE           // Not probed at all, we only that it is there
-	PATH_STATE_PROBED         // Probed, but not selected for pinging. We know its RTT.
-	PATH_STATE_TIMEOUT        // This one timeouted, don't use it for a while
-	PATH_STATE_DOWN           // Got an SCMP error, ignore it for now
-	PATH_STATE_UNKNOWN        // Something went wrong here, maybe not use the path
+	PATH_STATE_PING              = iota // Used for the current ping interval
+	PATH_STATE_IDLE                     // Not probed at all, we only that it is there
+	PATH_STATE_PROBED                   // Probed, but not selected for pinging. We know its RTT.
+	PATH_STATE_TIMEOUT                  // This one timeouted, don't use it for a while
+	PATH_STATE_DOWN                     // Got an SCMP external interface down error, ignore it for now
+	PATH_STATE_UNKNOWN                  // Something went wrong here, maybe not use the path
+	PATH_STATE_INTERNAL_DOWN            // Got an SCMP internal connectivity down error, ignore it for now
+	PATH_STATE_UNREACHABLE              // Got an SCMP destination unreachable error
+	PATH_STATE_TOO_BIG                  // Got an SCMP packet too big error
+	PATH_STATE_PARAM_PROBLEM            // Got an SCMP parameter problem error, e.g. the path expired
+	PATH_STATE_LOCAL_UNAVAILABLE        // Not probed, the local SCION stack is unavailable. Says nothing about the path
 )
 
+var pathStateNames = []string{"PATH_STATE_PING", "PATH_STATE_IDLE", "PATH_STATE_PROBED", "PATH_STATE_TIMEOUT",
+	"PATH_STATE_DOWN", "PATH_STATE_UNKNOWN", "PATH_STATE_INTERNAL_DOWN", "PATH_STATE_UNREACHABLE", "PATH_STATE_TOO_BIG",
+	"PATH_STATE_PARAM_PROBLEM", "PATH_STATE_LOCAL_UNAVAILABLE"}
+
+func pathStateName(state int) string {
+	if state < 0 || state >= len(pathStateNames) {
+		return strconv.Itoa(state)
+	}
+	return pathStateNames[state]
+}
+
+// isFailedPathState returns true if the probe of a path in this state did not get an echo reply.
+func isFailedPathState(state int) bool {
+	return state == PATH_STATE_UNKNOWN || isDownPathState(state)
+}
+
+// isDownPathState returns true if the path timed out or got an SCMP error telling us it is broken.
+func isDownPathState(state int) bool {
+	switch state {
+	case PATH_STATE_TIMEOUT, PATH_STATE_DOWN, PATH_STATE_INTERNAL_DOWN, PATH_STATE_UNREACHABLE,
+		PATH_STATE_TOO_BIG, PATH_STATE_PARAM_PROBLEM:
+		return true
+	}
+	return false
+}
+
+// pathStateFromUpdate maps the state of a ping reply to the state of the probed path.
+func pathStateFromUpdate(state State) int {
+	switch state {
+	case PathDown:
+		return PATH_STATE_DOWN
+	case InternalConnectivityDown:
+		return PATH_STATE_INTERNAL_DOWN
+	case DestinationUnreachable:
+		return PATH_STATE_UNREACHABLE
+	case PacketTooBig:
+		return PATH_STATE_TOO_BIG
+	case ParameterProblem:
+		return PATH_STATE_PARAM_PROBLEM
+	case SCMPUnknown:
+		return PATH_STATE_UNKNOWN
+	}
+	return PATH_STATE_PROBED
+}
+
 // The result of probing a destination, containing the status of all paths to that destination.
 type DestinationProbeResult struct {
 	Paths []PathStatus
@@ -51,6 +103,12 @@ type PathStatus struct {
 	Path        snet.Path
 	Fingerprint string
 	RTT         int64
+	ProbeType   string
+	// Set for one-way delay probes that were reflected
+	OneWayDelay *OneWayDelay
+	// Where the path broke, if we got an SCMP error for it
+	ErrorIA        addr.IA
+	ErrorInterface uint64
 }
 
 // Represents a destination to probe, containing the remote address and the status of all paths to that destination.
@@ -59,6 +117,26 @@ type PingDestination struct {
 	sync.Mutex
 	PathStates []PathStatus
 	RemoteAddr snet.UDPAddr
+	// Probe types to send on each path, the first one determines the path state
+	ProbeTypes []string
+	// SCION UDP port of the responder for UDP probes
+	UDPPort int
+}
+
+// primaryProbeType returns the probe type that determines the path states of the destination.
+func (dest *PingDestination) primaryProbeType() string {
+	if len(dest.ProbeTypes) == 0 {
+		return probeTypeSCMP
+	}
+	return dest.ProbeTypes[0]
+}
+
+// probeTypes returns the probe types to send on each path, at least SCMP echo.
+func (dest *PingDestination) probeTypes() []string {
+	if len(dest.ProbeTypes) == 0 {
+		return []string{probeTypeSCMP}
+	}
+	return dest.ProbeTypes
 }
 
 type PathProber struct {
@@ -69,27 +147,52 @@ type PathProber struct {
 	localAddr       net.UDPAddr
 	destinations    map[string]*PingDestination
 	Exporter        DataExporter
-	pingers         map[string]*pinger
+	pingers         map[string]*pinger // Pinger of the pingerPool serving each destination
+	pingerPool      *pingerPool
+	pingersMutex    sync.RWMutex // Guards hostContext, localIA, localAddr, pingers and pingerPool, which are replaced when reconnecting
+	faultLocalizer  *FaultLocalizer
+	clockOffsets    *clockOffsetEstimator
+	MeshID          string // Results are tagged with it in mesh mode
+	// False while the local SCION daemon or dispatcher is unavailable
+	localStackAvailable atomic.Bool
 }
 
 // NewPathProber creates a new PathProber.
 // The maxPathsToProbe parameter specifies the maximum number of paths to probe for each destination, to avoid probing dozens of paths.
 func NewPathProber(maxPathsToProbe int, maxPathsToPing int) *PathProber {
-	return &PathProber{
+	pb := &PathProber{
 		destinations:    make(map[string]*PingDestination, maxPathsToProbe),
 		maxPathsToProbe: maxPathsToProbe,
 		maxPathsToPing:  maxPathsToPing,
 		Exporter:        NewSQLiteExporter(),
 		pingers:         make(map[string]*pinger),
+		faultLocalizer:  newLoggingFaultLocalizer(),
+		clockOffsets:    newClockOffsetEstimator(),
 	}
+	pb.localStackAvailable.Store(true)
+	return pb
+}
+
+// newLoggingFaultLocalizer reports links as suspected once at least half of the paths and
+// at least 2 paths through them failed, and logs every suspected link.
+func newLoggingFaultLocalizer() *FaultLocalizer {
+	fl := NewFaultLocalizer(0.5, 2)
+	fl.OnSuspect = func(event LinkFaultEvent) {
+		Log.Warnf("Suspected faulty link %s: %d/%d paths through it failed, affected destinations: %s",
+			event.Interface, event.FailingPaths, event.TotalPaths, strings.Join(event.Destinations, ","))
+	}
+	return fl
 }
 
 // Inits the prober and does a path lookup to all destinations.
 // TODO: Parallelize this
 func (pb *PathProber) InitAndLookup(hc hostContext) error {
+	localAddr := net.UDPAddr{IP: getSaddr(hc.hostInLocalAS), Port: 0}
+	pb.pingersMutex.Lock()
 	pb.hostContext = &hc
-	pb.localAddr = net.UDPAddr{IP: getSaddr(hc.hostInLocalAS), Port: 0}
+	pb.localAddr = localAddr
 	pb.localIA = hc.ia
+	pb.pingersMutex.Unlock()
 
 	err := pb.Exporter.InitDaily()
 	if err != nil {
@@ -119,37 +222,15 @@ func (pb *PathProber) InitAndLookup(hc hostContext) error {
 			}
 			return nil
 		})
+	}
 
-		ctx := context.TODO()
-		replies := make(chan reply, 50)
-		id := snet.RandomSCMPIdentifer()
-		handler := scmpHandler{
-			id:      id,
-			replies: replies,
-		}
-		udpAddr := pb.localAddr
-
-		conn, port, err := newSCIONConn(ctx, handler, pb.localIA, udpAddr)
-		if err != nil {
-			return err
-		}
-
-		udpAddr.Port = int(port)
-
-		p := pinger{
-			timeout:        time.Second,
-			pld:            make([]byte, 8),
-			id:             id,
-			conn:           conn,
-			local:          &snet.UDPAddr{IA: pb.localIA, Host: &udpAddr},
-			replies:        replies,
-			errHandler:     nil,
-			updateHandler:  nil,
-			updateHandlers: make(map[int]func(Update)),
-		}
-		p.runReceiveLoop()
-		pb.pingers[destStr] = &p
-
+	pool, err := newPingerPool(context.TODO(), pingerPoolSize(len(pb.destinations)), hc.ia, localAddr)
+	if err != nil {
+		// The localStackMonitor keeps trying to reconnect
+		Log.Error("Failed to open SCION connections: ", err)
+		pb.setLocalStackAvailable(false)
+	} else {
+		pb.setPingerPool(pool)
 	}
 
 	err = eg.Wait()
@@ -160,6 +241,126 @@ func (pb *PathProber) InitAndLookup(hc hostContext) error {
 	return nil
 }
 
+// reconnect connects to the local SCION stack again and re-registers the pingers.
+func (pb *PathProber) reconnect() error {
+	hc, err := initHostContext()
+	if err != nil {
+		return err
+	}
+	localAddr := net.UDPAddr{IP: getSaddr(hc.hostInLocalAS), Port: 0}
+	pool, err := newPingerPool(context.TODO(), pingerPoolSize(len(pb.destinations)), hc.ia, localAddr)
+	if err != nil {
+		hc.sciond.Close()
+		return err
+	}
+
+	setHost(hc)
+	pb.pingersMutex.Lock()
+	oldHostContext := pb.hostContext
+	pb.hostContext = &hc
+	pb.localAddr = localAddr
+	pb.localIA = hc.ia
+	oldPool := pb.assignPingerPool(pool)
+	pb.pingersMutex.Unlock()
+
+	if oldPool != nil {
+		oldPool.Close()
+	}
+	if oldHostContext != nil && oldHostContext.sciond != nil {
+		oldHostContext.sciond.Close()
+	}
+	return nil
+}
+
+// setPingerPool assigns the pingers of the pool to the destinations and returns the previous pool.
+func (pb *PathProber) setPingerPool(pool *pingerPool) *pingerPool {
+	pb.pingersMutex.Lock()
+	defer pb.pingersMutex.Unlock()
+	return pb.assignPingerPool(pool)
+}
+
+// assignPingerPool is setPingerPool with the pingersMutex held.
+func (pb *PathProber) assignPingerPool(pool *pingerPool) *pingerPool {
+	oldPool := pb.pingerPool
+	pb.pingerPool = pool
+	for destStr := range pb.destinations {
+		pb.pingers[destStr] = pool.forDestination(destStr)
+	}
+	return oldPool
+}
+
+func (pb *PathProber) getPingerPool() *pingerPool {
+	pb.pingersMutex.RLock()
+	defer pb.pingersMutex.RUnlock()
+	return pb.pingerPool
+}
+
+// pingerFor returns the pinger serving the destination, nil if there are no SCION connections.
+func (pb *PathProber) pingerFor(destIsdAS string) *pinger {
+	pb.pingersMutex.RLock()
+	defer pb.pingersMutex.RUnlock()
+	return pb.pingers[destIsdAS]
+}
+
+// srcSCIONAddr returns the local address the results are sent from, as recorded in the results.
+func (pb *PathProber) srcSCIONAddr() string {
+	pb.pingersMutex.RLock()
+	defer pb.pingersMutex.RUnlock()
+	return fmt.Sprintf("%s,%s", pb.localIA.String(), pb.localAddr.String())
+}
+
+func (pb *PathProber) setLocalStackAvailable(available bool) {
+	pb.localStackAvailable.Store(available)
+}
+
+func (pb *PathProber) LocalStackAvailable() bool {
+	return pb.localStackAvailable.Load()
+}
+
+// hasLocalStackDown returns true if any of the paths could not be probed because the local SCION stack was unavailable.
+func hasLocalStackDown(paths []PathStatus) bool {
+	for _, path := range paths {
+		if path.State == PATH_STATE_LOCAL_UNAVAILABLE {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsOfProbeType returns the paths probed with the given probe type.
+func pathsOfProbeType(paths []PathStatus, probeType string) []PathStatus {
+	filtered := make([]PathStatus, 0, len(paths))
+	for _, path := range paths {
+		if path.ProbeType == probeType {
+			filtered = append(filtered, path)
+		}
+	}
+	return filtered
+}
+
+// updatePathStates stores the outcome of probing paths to the destination, so that
+// path-down outcomes are remembered for the path they were reported for.
+func (dest *PingDestination) updatePathStates(probed []PathStatus) {
+	dest.Lock()
+	defer dest.Unlock()
+	for _, probedPath := range probed {
+		if probedPath.State == PATH_STATE_LOCAL_UNAVAILABLE || probedPath.ProbeType != dest.primaryProbeType() {
+			continue
+		}
+		for i := range dest.PathStates {
+			if dest.PathStates[i].Fingerprint != probedPath.Fingerprint {
+				continue
+			}
+			dest.PathStates[i].State = probedPath.State
+			dest.PathStates[i].ErrorIA = probedPath.ErrorIA
+			dest.PathStates[i].ErrorInterface = probedPath.ErrorInterface
+			if probedPath.RTT > 0 {
+				dest.PathStates[i].RTT = probedPath.RTT
+			}
+		}
+	}
+}
+
 // Initially set all destinations to probe, needs to be done before InitAndLookup
 func (pb *PathProber) SetDestinations(destinations []snet.UDPAddr) {
 	for _, dest := range destinations {
@@ -170,6 +371,88 @@ func (pb *PathProber) SetDestinations(destinations []snet.UDPAddr) {
 	}
 }
 
+// SetProbeTypes sets the probe types and the responder port for UDP probes of a destination,
+// by default only SCMP echo requests are sent.
+func (pb *PathProber) SetProbeTypes(destination string, probeTypes []string, udpPort int) error {
+	dest, ok := pb.destinations[destination]
+	if !ok {
+		return fmt.Errorf("destination %s not found", destination)
+	}
+	for _, probeType := range probeTypes {
+		if probeType != probeTypeSCMP && probeType != probeTypeUDP && probeType != probeTypeOWD {
+			return fmt.Errorf("unknown probe type %q for %s", probeType, destination)
+		}
+	}
+	if udpPort == 0 {
+		udpPort = defaultResponderPort
+	}
+	dest.ProbeTypes = probeTypes
+	dest.UDPPort = udpPort
+	return nil
+}
+
+// probePath sends a single probe of the given type over the path and waits for its outcome.
+func (pb *PathProber) probePath(destIsdAS string, dest *PingDestination, path snet.Path, fingerprint string, probeType string) (PathStatus, error) {
+	pinger := pb.pingerFor(destIsdAS)
+	if pinger == nil || !pb.LocalStackAvailable() {
+		return PathStatus{
+			State:       PATH_STATE_LOCAL_UNAVAILABLE,
+			Path:        path,
+			Fingerprint: fingerprint,
+			ProbeType:   probeType,
+		}, nil
+	}
+
+	rAddr := dest.RemoteAddr.Copy()
+	rAddr.Path = path.Dataplane()
+	rAddr.NextHop = path.UnderlayNextHop()
+
+	// Buffered, so a reply arriving after the timeout doesn't block the receive loop
+	updateChan := make(chan Update, 1)
+	timeChan := time.After(700 * time.Millisecond)
+	handler := func(u Update) {
+		updateChan <- u
+	}
+	var err error
+	if probeType == probeTypeUDP || probeType == probeTypeOWD {
+		rAddr.Host.Port = dest.UDPPort
+		err = pinger.SendUDP(rAddr, probeType, handler)
+	} else {
+		err = pinger.Send(rAddr, handler)
+	}
+	// TODO: Error Handling, is this a path timeout or path down?
+	if err != nil {
+		return PathStatus{}, err
+	}
+
+	select {
+	case <-timeChan:
+		Log.Debug("Timeout for ", probeType, " probe to ", rAddr, " via ", fingerprint)
+		return PathStatus{
+			State:       PATH_STATE_TIMEOUT,
+			Path:        path,
+			Fingerprint: fingerprint,
+			ProbeType:   probeType,
+		}, nil
+	case update := <-updateChan:
+		status := PathStatus{
+			State:          pathStateFromUpdate(update.State),
+			Path:           path,
+			RTT:            update.RTT.Milliseconds(),
+			Fingerprint:    fingerprint,
+			ProbeType:      probeType,
+			ErrorIA:        update.ErrorIA,
+			ErrorInterface: update.ErrorInterface,
+		}
+		sample := owdSample{t1: update.Sent, t2: update.RemoteReceived, t3: update.RemoteSent, t4: update.Received}
+		if probeType == probeTypeOWD && update.State == Success && sample.valid() {
+			owd := pb.clockOffsets.estimate(destIsdAS, sample)
+			status.OneWayDelay = &owd
+		}
+		return status, nil
+	}
+}
+
 // Probe all paths to a given destination, returning the results.
 func (pb *PathProber) Probe(destIsdAS string) (*DestinationProbeResult, error) {
 
@@ -182,86 +465,40 @@ func (pb *PathProber) Probe(destIsdAS string) (*DestinationProbeResult, error) {
 		Paths: make([]PathStatus, 0),
 	}
 
-	if len(dest.PathStates) == 0 {
+	dest.Lock()
+	pathStates := append([]PathStatus(nil), dest.PathStates...)
+	dest.Unlock()
+
+	if len(pathStates) == 0 {
 		Log.Error("No paths to probe for ", destIsdAS)
 	}
 
 	var eg errgroup.Group
+	var resultMutex sync.Mutex
 	lookuptime := time.Now().UTC()
-	for i, pathStatus := range dest.PathStates {
+	for i, pathStatus := range pathStates {
 		if i >= pb.maxPathsToProbe {
 			break
 		}
-		eg.Go(func() error {
-			// TODO: Reuse pingers here, this is not optimal to create a new pinger for each path
-			// But I haven't found a way to reuse them yet
-
-			pinger := pb.pingers[destIsdAS]
-
-			rAddr := dest.RemoteAddr.Copy()
-			rAddr.Path = pathStatus.Path.Dataplane()
-			rAddr.NextHop = pathStatus.Path.UnderlayNextHop()
-
-			var update Update
-			successChan := make(chan bool)
-			timeChan := time.After(700 * time.Millisecond)
-			// Log.Debug("Sending ping to ", rAddr, " via ", pathStatus.Fingerprint)
-			err := pinger.Send(rAddr, func(u Update) {
-				// Log.Debug("Got update ", u, " from ", rAddr, " via ", pathStatus.Fingerprint)
-				update = u
-				successChan <- true
-			})
-
-			// TODO: Error Handling, is this a path timeout or path down?
-			if err != nil {
-				return err
-			}
-
-			success := false
-
-			select {
-			case <-timeChan:
-				Log.Debug("Timeout for ", rAddr, " via ", pathStatus.Fingerprint)
-				break
-			case <-successChan:
-				success = true
-				break
-			}
-
-			if success {
-				rtt := update.RTT.Milliseconds()
-
-				state := PATH_STATE_PROBED
-				switch update.State {
-				case PathDown:
-					state = PATH_STATE_DOWN
-				case SCMPUnknown:
-					state = PATH_STATE_UNKNOWN
+		for _, probeType := range dest.probeTypes() {
+			eg.Go(func() error {
+				probed, err := pb.probePath(destIsdAS, dest, pathStatus.Path, pathStatus.Fingerprint, probeType)
+				if err != nil {
+					return err
 				}
-
-				pathStatus.RTT = rtt
-				result.Paths = append(result.Paths, PathStatus{
-					State:       state,
-					Path:        pathStatus.Path,
-					RTT:         rtt,
-					Fingerprint: pathStatus.Fingerprint,
-				})
-			} else {
-				result.Paths = append(result.Paths, PathStatus{
-					State:       PATH_STATE_TIMEOUT,
-					Path:        pathStatus.Path,
-					Fingerprint: pathStatus.Fingerprint,
-				})
-			}
-
-			return nil
-		})
+				resultMutex.Lock()
+				result.Paths = append(result.Paths, probed)
+				resultMutex.Unlock()
+				return nil
+			})
+		}
 	}
 
 	err := eg.Wait()
 	if err != nil {
 		Log.Debug("Not all probes to dest ", destIsdAS, " successfull")
 	}
+	dest.updatePathStates(result.Paths)
 
 	successCount := 0
 	minRTT := int64(10000000000)
@@ -270,9 +507,12 @@ func (pb *PathProber) Probe(destIsdAS string) (*DestinationProbeResult, error) {
 	minHops := 100000
 	maxHops := 0
 
+	// Other probe types are sent on the same paths, the statistics are about the primary one
+	primaryPaths := pathsOfProbeType(result.Paths, dest.primaryProbeType())
+
 	var pathStrings []string
 	var pathFingerprints []string
-	for _, path := range result.Paths {
+	for _, path := range primaryPaths {
 
 		if path.RTT > 0 {
 			successCount++
@@ -296,20 +536,12 @@ func (pb *PathProber) Probe(destIsdAS string) (*DestinationProbeResult, error) {
 			}
 
 		}
-		interfacesString := ""
-		for i, iface := range path.Path.Metadata().Interfaces {
-			if i == 0 {
-				interfacesString = iface.String()
-				continue
-			}
-			interfacesString += "->" + iface.String()
-		}
-		pathStrings = append(pathStrings, interfacesString)
+		pathStrings = append(pathStrings, pathInterfacesString(path.Path))
 		pathFingerprints = append(pathFingerprints, path.Fingerprint)
 	}
 
 	ps := PathStatistics{
-		SrcSCIONAddr:   fmt.Sprintf("%s,%s", pb.localIA.String(), pb.localAddr.String()),
+		SrcSCIONAddr:   pb.srcSCIONAddr(),
 		DstSCIONAddr:   destIsdAS,
 		Paths:          strings.Join(pathStrings, ","),
 		Fingerprints:   strings.Join(pathFingerprints, ","),
@@ -320,8 +552,11 @@ func (pb *PathProber) Probe(destIsdAS string) (*DestinationProbeResult, error) {
 		MaxHops:        maxHops,
 		LookupTime:     lookuptime,
 		ActivePaths:    successCount,
-		ProbedPaths:    len(result.Paths),
-		AvailablePaths: len(pb.destinations[destIsdAS].PathStates),
+		ProbedPaths:    len(primaryPaths),
+		AvailablePaths: len(pathStates),
+		LocalStackDown: hasLocalStackDown(primaryPaths),
+		ProbeType:      dest.primaryProbeType(),
+		MeshID:         pb.MeshID,
 	}
 
 	err = pb.Exporter.WritePathStatistic(ps)
@@ -345,6 +580,8 @@ func (pb *PathProber) UpdatePathList(destStr string, dest *PingDestination) erro
 
 	Log.Debug("Found ", len(paths), " paths to destination ", destStr)
 
+	dest.Lock()
+	defer dest.Unlock()
 	for _, path := range paths {
 		fp := calculateFingerprint(path)
 		foundIndex := -1
@@ -356,7 +593,7 @@ func (pb *PathProber) UpdatePathList(destStr string, dest *PingDestination) erro
 		}
 
 		// We need to update the path with a new entry
-		if foundIndex > 0 {
+		if foundIndex >= 0 {
 			Log.Debug("Updating path ", path, " for ", destStr)
 			dest.PathStates[foundIndex].Path = path
 		} else {
@@ -374,9 +611,14 @@ func (pb *PathProber) UpdatePathList(destStr string, dest *PingDestination) erro
 // Iterate over all destinations and probe all paths to each destination in parallel.
 func (pb *PathProber) ProbeAll() (*PathProbeResult, error) {
 	var eg errgroup.Group
+	var resultMutex sync.Mutex
 	result := &PathProbeResult{
 		Destinations: make(map[string]*DestinationProbeResult),
 	}
+	// Only the primary probe type of each destination, a missing UDP responder is not a link fault
+	primaryResult := &PathProbeResult{
+		Destinations: make(map[string]*DestinationProbeResult),
+	}
 	for destStr, dest := range pb.destinations {
 		eg.Go(func() error {
 
@@ -390,15 +632,37 @@ func (pb *PathProber) ProbeAll() (*PathProbeResult, error) {
 			if err != nil {
 				return err
 			}
+			resultMutex.Lock()
 			result.Destinations[destAddrStr] = probeResult
+			primaryResult.Destinations[destAddrStr] = &DestinationProbeResult{
+				Paths: pathsOfProbeType(probeResult.Paths, dest.primaryProbeType()),
+			}
+			resultMutex.Unlock()
 			return nil
 		})
 	}
 
 	err := eg.Wait()
+
+	// Correlate failing paths across all destinations to find the faulty links
+	pb.faultLocalizer.Localize(primaryResult)
+
 	return result, err
 }
 
+// pathInterfacesString describes the path by its interfaces, e.g. 1-ff00:0:110#1->1-ff00:0:111#2.
+func pathInterfacesString(path snet.Path) string {
+	interfacesString := ""
+	for i, iface := range path.Metadata().Interfaces {
+		if i == 0 {
+			interfacesString = iface.String()
+			continue
+		}
+		interfacesString += "->" + iface.String()
+	}
+	return interfacesString
+}
+
 // Probe the selected paths from pingPathSets to a given destination, returning the results.
 func (pb *PathProber) ProbeDestBest(destIsdAS string) (*DestinationProbeResult, error) {
 
@@ -415,71 +679,27 @@ func (pb *PathProber) ProbeDestBest(destIsdAS string) (*DestinationProbeResult,
 	pingPathSetsPaths := pingPathSets.Paths[dest.RemoteAddr.String()]
 	pingPathSets.Unlock()
 	var eg errgroup.Group
+	var resultMutex sync.Mutex
 
 	for _, path := range pingPathSetsPaths {
-		eg.Go(func() error {
-
-			pinger := pb.pingers[destIsdAS]
-
-			rAddr := dest.RemoteAddr.Copy()
-			rAddr.Path = path.Dataplane()
-			rAddr.NextHop = path.UnderlayNextHop()
-
-			var update Update
-			successChan := make(chan bool)
-			timeChan := time.After(700 * time.Millisecond)
-			Log.Debug("Sending bestProbe to ", rAddr, " via ", path)
-			err := pinger.Send(rAddr, func(u Update) {
-				Log.Debug("Got update for bestprobe ", u, " from ", rAddr, " via ", path)
-				update = u
-				successChan <- true
-			})
-
-			success := false
-
-			select {
-			case <-timeChan:
-				Log.Debug("Timeout for ", rAddr, " via ", path)
-				break
-			case <-successChan:
-				success = true
-				break
-			}
-
-			// TODO: Error Handling, is this a path timeout or path down?
-			if err != nil {
-				return err
-			}
-
-			if success {
-				rtt := update.RTT.Milliseconds()
-				state := PATH_STATE_PROBED
-				switch update.State {
-				case PathDown:
-					state = PATH_STATE_DOWN
-				case SCMPUnknown:
-					state = PATH_STATE_UNKNOWN
+		fingerprint := calculateFingerprint(path)
+		for _, probeType := range dest.probeTypes() {
+			eg.Go(func() error {
+				Log.Debug("Sending bestProbe to ", destIsdAS, " via ", path)
+				probed, err := pb.probePath(destIsdAS, dest, path, fingerprint, probeType)
+				if err != nil {
+					return err
 				}
-
-				result.Paths = append(result.Paths, PathStatus{
-					State:       state,
-					Path:        path,
-					RTT:         rtt,
-					Fingerprint: calculateFingerprint(path),
-				})
-			} else {
-				result.Paths = append(result.Paths, PathStatus{
-					State:       PATH_STATE_TIMEOUT,
-					Path:        path,
-					Fingerprint: calculateFingerprint(path),
-				})
-			}
-
-			return nil
-		})
+				resultMutex.Lock()
+				result.Paths = append(result.Paths, probed)
+				resultMutex.Unlock()
+				return nil
+			})
+		}
 	}
 
 	err := eg.Wait()
+	dest.updatePathStates(result.Paths)
 
 	return result, err
 }
@@ -490,6 +710,7 @@ func (pb *PathProber) ProbeBest() (*PathProbeResult, error) {
 	result := &PathProbeResult{
 		Destinations: make(map[string]*DestinationProbeResult),
 	}
+	var resultMutex sync.Mutex
 	t := time.Now()
 	Log.Info("Probing best run... ")
 	timeout := time.After(2 * time.Second)
@@ -501,39 +722,70 @@ func (pb *PathProber) ProbeBest() (*PathProbeResult, error) {
 			if err != nil {
 				return err
 			}
-			minRTT := int64(1000000)
-			successCount := 0
-
-			Log.Debug("Probed ", destAddrStr, " got entries ", len(probeResult.Paths))
-			var minRTTPathFingerPrint string
-			for _, path := range probeResult.Paths {
-				Log.Debug("Path1 ", path.Path, " has RTT ", path.RTT)
-				if path.RTT > 0 {
-					successCount++
-					if path.RTT < minRTT {
-						minRTT = path.RTT
-						minRTTPathFingerPrint = path.Fingerprint
+			// One result per probe type, so their RTTs can be compared on the same paths
+			for _, probeType := range dest.probeTypes() {
+				paths := pathsOfProbeType(probeResult.Paths, probeType)
+				minRTT := int64(1000000)
+				successCount := 0
+
+				Log.Debug("Probed ", destAddrStr, " with ", probeType, " got entries ", len(paths))
+				var minRTTPathFingerPrint string
+				for _, path := range paths {
+					Log.Debug("Path1 ", path.Path, " has RTT ", path.RTT)
+					if path.RTT > 0 {
+						successCount++
+						if path.RTT < minRTT {
+							minRTT = path.RTT
+							minRTTPathFingerPrint = path.Fingerprint
+						}
 					}
 				}
-			}
 
-			pr := PingResult{
-				SrcSCIONAddr:    fmt.Sprintf("%s,%s", pb.localIA.String(), pb.localAddr.String()),
-				DstSCIONAddr:    destAddrStr,
-				Success:         successCount > 0,
-				RTT:             float64(minRTT),
-				Fingerprint:     minRTTPathFingerPrint,
-				PingTime:        pingtime,
-				SuccessfulPings: successCount,
-				MaxPings:        len(probeResult.Paths),
+				pr := PingResult{
+					SrcSCIONAddr:    pb.srcSCIONAddr(),
+					DstSCIONAddr:    destAddrStr,
+					Success:         successCount > 0,
+					RTT:             float64(minRTT),
+					Fingerprint:     minRTTPathFingerPrint,
+					PingTime:        pingtime,
+					SuccessfulPings: successCount,
+					MaxPings:        len(paths),
+					LocalStackDown:  hasLocalStackDown(paths),
+					ProbeType:       probeType,
+					MeshID:          pb.MeshID,
+				}
+				err = pb.Exporter.WritePingResult(pr)
+				if err != nil {
+					Log.Error("Error writing ping result for ", destAddrStr, ":", err)
+					return err
+				}
 			}
-			err = pb.Exporter.WritePingResult(pr)
-			if err != nil {
-				Log.Error("Error writing ping result for ", destAddrStr, ":", err)
-				return err
+
+			for _, path := range probeResult.Paths {
+				if path.OneWayDelay == nil {
+					continue
+				}
+				err = pb.Exporter.WriteOneWayDelayResult(OneWayDelayResult{
+					SrcSCIONAddr: pb.srcSCIONAddr(),
+					DstSCIONAddr: destAddrStr,
+					Fingerprint:  path.Fingerprint,
+					ForwardDelay: milliseconds(path.OneWayDelay.Forward),
+					ReverseDelay: milliseconds(path.OneWayDelay.Reverse),
+					ClockOffset:  milliseconds(path.OneWayDelay.ClockOffset),
+					SyncQuality:  path.OneWayDelay.SyncQuality,
+					SyncError:    milliseconds(path.OneWayDelay.SyncError),
+					ProbeTime:    pingtime,
+					MeshID:       pb.MeshID,
+				})
+				if err != nil {
+					Log.Error("Error writing one-way delay result for ", destAddrStr, ":", err)
+					return err
+				}
 			}
 
+			resultMutex.Lock()
 			result.Destinations[destAddrStr] = probeResult
+			resultMutex.Unlock()
 			return nil
 		})
 	}
@@ -593,7 +845,7 @@ func (pb *PathProber) UpdatePathsToPing() error {
 *
 Path Selection Algorithm: (every 60 seconds or when at least 2 pings fail to a destination)
   - Input: NetworkState filled with rtt, number of hops, etc, Output: List of up to 3 paths
-  - 1. Ignore all paths that have state "down" or "timeout"
+  - 1. Ignore all paths that have state "down" (any SCMP error telling us the path is broken) or "timeout"
   - 2. If number of paths  <3 the choose all paths
   - 3. Select shortest path in number of hops
   - 4. Select lowest rtt path
@@ -604,12 +856,14 @@ func (pb *PathProber) SelectOptimalPathsToPing(pingDestination *PingDestination)
 
 	// 1. Ignore all paths that have state "down" or "timeout"
 	activePaths := make([]PathStatus, 0)
+	pingDestination.Lock()
 	for _, path := range pingDestination.PathStates {
-		if path.State == PATH_STATE_DOWN || path.State == PATH_STATE_TIMEOUT {
+		if isDownPathState(path.State) {
 			continue
 		}
 		activePaths = append(activePaths, path)
 	}
+	pingDestination.Unlock()
 
 	// 2. If number of paths  <3 the choose all paths
 	if len(activePaths) <= 3 {
@@ -644,17 +898,11 @@ func addMostDisjointPaths(shortestPaths []PathStatus, allPaths []PathStatus) []P
 
 	// Helper function to calculate disjointness score
 	calculateDisjointness := func(path1, path2 snet.Path) int {
-		meta1 := path1.Metadata()
-		meta2 := path2.Metadata()
-		interfaceSet := make(map[string]bool)
-
-		for _, iface := range meta1.Interfaces {
-			interfaceSet[iface.String()] = true
-		}
+		interfaceSet := pathInterfaceSet(path1)
 
 		disjointCount := 0
-		for _, iface := range meta2.Interfaces {
-			if !interfaceSet[iface.String()] {
+		for _, iface := range path2.Metadata().Interfaces {
+			if !interfaceSet[iface] {
 				disjointCount++
 			}
 		}
@@ -3,17 +3,19 @@ package main
 import (
 	"context"
 	"encoding/binary"
-	"math"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/netip"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/gopacket"
 	"github.com/scionproto/scion/pkg/addr"
-	"github.com/scionproto/scion/pkg/log"
 	"github.com/scionproto/scion/pkg/private/common"
 	"github.com/scionproto/scion/pkg/private/serrors"
+	"github.com/scionproto/scion/pkg/slayers"
 	"github.com/scionproto/scion/pkg/snet"
 	"github.com/scionproto/scion/pkg/snet/path"
 	"github.com/scionproto/scion/private/topology/underlay"
@@ -26,21 +28,74 @@ type pinger struct {
 	id            uint16
 	conn          snet.PacketConn
 	local         *snet.UDPAddr
-	replies       <-chan reply
+	replies       chan reply
 	errHandler    func(error)
 	updateHandler func(Update)
 
-	pld              []byte
-	sentSequence     int
-	receivedSequence int
-	stats            Stats
-	updateHandlers   map[int]func(Update)
+	sentSequence   uint64
+	stats          Stats
+	updateHandlers map[probeKey]pendingProbe
+
+	cancel      context.CancelFunc
+	readErrors  int // consecutive read errors on conn
+	writeErrors int // consecutive write errors on conn
+}
+
+// Consecutive read or write errors after which a pinger's connection is considered broken
+const maxConsecutiveConnErrors = 3
+
+// probeKey demultiplexes the replies of all destinations sharing a pinger, the identifier is unique per pinger.
+// The sequence number is carried in the echo payload, so unlike the 16 bit SCMP sequence number it doesn't
+// wrap around and collide under high probe rates.
+type probeKey struct {
+	remote   addr.IA
+	sequence uint64
+}
+
+type pendingProbe struct {
+	handler   func(Update)
+	sent      time.Time
+	probeType string
+}
+
+// Echo payload: send timestamp in unix nanoseconds, followed by the sequence number
+const echoPayloadLen = 16
+
+// newPinger opens a new SCION connection with its own SCMP identifier and starts receiving on it.
+func newPinger(ctx context.Context, localIA addr.IA, localAddr net.UDPAddr) (*pinger, error) {
+	replies := make(chan reply, 50)
+	handler := scmpHandler{
+		id:      snet.RandomSCMPIdentifer(),
+		replies: replies,
+	}
+
+	// Dispatcher-less, the identifier is replaced by the port we are bound to
+	conn, port, err := newSCIONConn(ctx, &handler, localIA, localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	localAddr.Port = int(port)
+
+	p := &pinger{
+		timeout:        time.Second,
+		id:             handler.id,
+		conn:           conn,
+		local:          &snet.UDPAddr{IA: localIA, Host: &localAddr},
+		replies:        replies,
+		errHandler:     nil,
+		updateHandler:  nil,
+		updateHandlers: make(map[probeKey]pendingProbe),
+	}
+	p.runReceiveLoop()
+	return p, nil
 }
 
-// TODO: Context, cancellation
 func (p *pinger) runReceiveLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
 
-	go p.drain(context.Background())
+	go p.drain(ctx)
 
 	go func() {
 		for reply := range p.replies {
@@ -52,30 +107,86 @@ func (p *pinger) runReceiveLoop() {
 			p.receive(reply)
 		}
 	}()
+
+	// Forget about requests that never got a reply
+	go func() {
+		ticker := time.NewTicker(p.timeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.expireHandlers(5 * p.timeout)
+			}
+		}
+	}()
 }
 
-func (p *pinger) Send(remote *snet.UDPAddr, updateHandler func(Update)) error {
-	p.Lock()
+// Close stops the receive loop and closes the connection.
+func (p *pinger) Close() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	return p.conn.Close()
+}
 
-	if p.sentSequence == math.MaxUint16 {
-		log.Info("Resetting sequence number for scion_pinger ", p.id)
-		p.sentSequence = 0
+// healthy returns an error if reading from or writing to the connection keeps failing,
+// e.g. because the dispatcher was restarted.
+func (p *pinger) healthy() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.readErrors >= maxConsecutiveConnErrors {
+		return fmt.Errorf("%d consecutive read errors on pinger %d", p.readErrors, p.id)
 	}
+	if p.writeErrors >= maxConsecutiveConnErrors {
+		return fmt.Errorf("%d consecutive write errors on pinger %d", p.writeErrors, p.id)
+	}
+	return nil
+}
 
-	sequence := p.sentSequence + 1
-	p.updateHandlers[sequence] = updateHandler
-	p.sentSequence = sequence
-	p.Unlock()
+// Send sends an SCMP echo request to the remote, the updateHandler is called with the reply.
+func (p *pinger) Send(remote *snet.UDPAddr, updateHandler func(Update)) error {
+	key := p.addHandler(remote.IA, probeTypeSCMP, updateHandler)
 
-	binary.BigEndian.PutUint64(p.pld, uint64(time.Now().UnixNano()))
+	pld := make([]byte, echoPayloadLen)
+	binary.BigEndian.PutUint64(pld[0:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(pld[8:16], key.sequence)
 	pkt, err := packSCMPrequest(p.local, remote, snet.SCMPEchoRequest{
 		Identifier: p.id,
-		SeqNumber:  uint16(sequence),
-		Payload:    p.pld,
+		SeqNumber:  uint16(key.sequence),
+		Payload:    pld,
 	})
 	if err != nil {
+		p.removeHandler(key)
 		return err
 	}
+	return p.write(key, pkt, remote)
+}
+
+// SendUDP sends a UDP probe to the port of the remote, the updateHandler is called with the reflected probe.
+func (p *pinger) SendUDP(remote *snet.UDPAddr, probeType string, updateHandler func(Update)) error {
+	key := p.addHandler(remote.IA, probeType, updateHandler)
+
+	probe := udpProbe{Sequence: key.sequence, Sent: time.Now()}
+	pkt, err := packUDPProbe(p.local, remote, probe.marshal())
+	if err != nil {
+		p.removeHandler(key)
+		return err
+	}
+	return p.write(key, pkt, remote)
+}
+
+func (p *pinger) addHandler(remote addr.IA, probeType string, updateHandler func(Update)) probeKey {
+	p.Lock()
+	defer p.Unlock()
+	p.sentSequence++
+	key := probeKey{remote: remote, sequence: p.sentSequence}
+	p.updateHandlers[key] = pendingProbe{handler: updateHandler, sent: time.Now(), probeType: probeType}
+	return key
+}
+
+func (p *pinger) write(key probeKey, pkt *snet.Packet, remote *snet.UDPAddr) error {
 	nextHop := remote.NextHop
 	if nextHop == nil && p.local.IA.Equal(remote.IA) {
 		nextHop = &net.UDPAddr{
@@ -86,69 +197,159 @@ func (p *pinger) Send(remote *snet.UDPAddr, updateHandler func(Update)) error {
 	}
 
 	if err := p.conn.WriteTo(pkt, nextHop); err != nil {
+		p.removeHandler(key)
+		p.Lock()
+		p.writeErrors++
+		p.Unlock()
 		return err
 	}
 
+	p.Lock()
 	p.stats.Sent++
+	p.writeErrors = 0
+	p.Unlock()
 
 	return nil
 }
 
+func (p *pinger) removeHandler(key probeKey) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.updateHandlers, key)
+}
+
+func (p *pinger) expireHandlers(maxAge time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	for key, pending := range p.updateHandlers {
+		if time.Since(pending.sent) > maxAge {
+			delete(p.updateHandlers, key)
+		}
+	}
+}
+
+// Stats returns a snapshot of the sent and received counters.
+func (p *pinger) Stats() Stats {
+	p.Lock()
+	defer p.Unlock()
+	return p.stats
+}
+
 func (p *pinger) receive(reply reply) {
 
 	var rtt time.Duration
-	var state State
-
-	// If there are any SCMP errors, we still land here but without the payload, so just parse it if there is enough space
-	if len(reply.Reply.Payload) == 8 && reply.Error == nil {
-		rtt = reply.Received.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(reply.Reply.Payload))))
-		switch {
-		case rtt > p.timeout:
-			state = AfterTimeout
-		case int(reply.Reply.SeqNumber) == p.receivedSequence:
-			state = Duplicate
-		case int(reply.Reply.SeqNumber) == p.receivedSequence+1:
-			state = Success
-			p.receivedSequence = int(reply.Reply.SeqNumber)
-		default:
+	state := Success
+	sequence := uint64(reply.Reply.SeqNumber)
+
+	// SCMP errors carry the payload of the request they were triggered by, if the quoted packet was complete
+	hasPayload := len(reply.Reply.Payload) >= echoPayloadLen
+	if hasPayload {
+		sequence = binary.BigEndian.Uint64(reply.Reply.Payload[8:16])
+		if reply.Error == nil {
+			rtt = reply.Received.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(reply.Reply.Payload[0:8]))))
+			if rtt > p.timeout {
+				state = AfterTimeout
+			}
 		}
 	}
 
-	// TODO: Handle other cases here?
+	update := Update{
+		RTT:      rtt,
+		Sequence: int(sequence),
+		Size:     reply.Size,
+		Source:   reply.Source,
+	}
+
+	// Keep where the path broke for SCMP errors, everything else we can't classify
 	if reply.Error != nil {
-		if strings.Contains(reply.Error.Error(), "external interface down") {
-			state = PathDown
+		var scmpErr *scmpError
+		if errors.As(reply.Error, &scmpErr) {
+			state = scmpErr.State
+			update.SCMPTypeCode = scmpErr.TypeCode
+			update.ErrorIA = scmpErr.IA
+			update.ErrorInterface = scmpErr.Interface
+			update.ErrorIngressInterface = scmpErr.Ingress
+			update.MTU = scmpErr.MTU
 		} else {
 			state = SCMPUnknown
 		}
 	}
 
+	update.State = state
+	p.complete(probeKey{remote: reply.Remote, sequence: sequence}, !hasPayload && reply.Error != nil, update)
+}
+
+// receiveUDP handles a UDP probe reflected by the responder on the remote.
+func (p *pinger) receiveUDP(pkt *snet.Packet, received time.Time) {
+	udp, ok := pkt.Payload.(snet.UDPPayload)
+	if !ok {
+		return
+	}
+	probe, err := parseUDPProbe(udp.Payload)
+	if err != nil {
+		Log.Debug("Dropping UDP packet from ", pkt.Source, ": ", err)
+		return
+	}
+
+	// Time the probe spent in the responder is not part of the RTT
+	rtt := received.Sub(probe.Sent)
+	if !probe.RemoteReceived.IsZero() && !probe.RemoteSent.IsZero() {
+		rtt -= probe.RemoteSent.Sub(probe.RemoteReceived)
+	}
+
+	update := Update{
+		RTT:            rtt,
+		Sequence:       int(probe.Sequence),
+		Size:           len(pkt.Bytes),
+		Source:         pkt.Source,
+		State:          Success,
+		Sent:           probe.Sent,
+		Received:       received,
+		RemoteReceived: probe.RemoteReceived,
+		RemoteSent:     probe.RemoteSent,
+	}
+	if update.RTT > p.timeout {
+		update.State = AfterTimeout
+	}
+	p.complete(probeKey{remote: pkt.Source.IA, sequence: probe.Sequence}, false, update)
+}
+
+// complete passes the update to the handler of the probe it belongs to. If the full sequence number
+// is not known, e.g. from a truncated quote in an SCMP error, it falls back to the 16 bit one.
+func (p *pinger) complete(key probeKey, fallback16 bool, update Update) {
+	p.Lock()
 	p.stats.Received++
-	if p.updateHandler != nil {
-		p.updateHandler(Update{
-			RTT:      rtt,
-			Sequence: int(reply.Reply.SeqNumber),
-			Size:     reply.Size,
-			Source:   reply.Source,
-			State:    state,
-		})
+	p.readErrors = 0
+	pending, ok := p.updateHandlers[key]
+	if !ok && fallback16 {
+		for candidate, candidatePending := range p.updateHandlers {
+			if candidate.remote == key.remote && uint16(candidate.sequence) == uint16(key.sequence) {
+				key, pending, ok = candidate, candidatePending, true
+				update.Sequence = int(candidate.sequence)
+				break
+			}
+		}
 	}
+	if ok {
+		delete(p.updateHandlers, key)
+		update.ProbeType = pending.probeType
+	} else if update.State == Success {
+		update.State = Duplicate
+	}
+	p.Unlock()
 
-	if handler, ok := p.updateHandlers[int(reply.Reply.SeqNumber)]; ok {
-		p.Lock()
-		handler(Update{
-			RTT:      rtt,
-			Sequence: int(reply.Reply.SeqNumber),
-			Size:     reply.Size,
-			Source:   reply.Source,
-			State:    state,
-		})
-		delete(p.updateHandlers, int(reply.Reply.SeqNumber))
-		p.Unlock()
+	if p.updateHandler != nil {
+		p.updateHandler(update)
+	}
+	if ok {
+		pending.handler(update)
 	}
 }
 
 func (p *pinger) drain(ctx context.Context) {
+	// Our scmpHandler is the only sender on replies and it is only called from within ReadFrom
+	defer close(p.replies)
+
 	var last time.Time
 	for {
 		select {
@@ -157,17 +358,46 @@ func (p *pinger) drain(ctx context.Context) {
 		default:
 			var pkt snet.Packet
 			var ov net.UDPAddr
-			if err := p.conn.ReadFrom(&pkt, &ov); err != nil && p.errHandler != nil {
+			err := p.conn.ReadFrom(&pkt, &ov)
+			p.Lock()
+			if err == nil {
+				p.readErrors = 0
+			} else if isSocketError(err) {
+				p.readErrors++
+			}
+			p.Unlock()
+			if err == nil {
+				// SCMP is passed to our scmpHandler, anything else should be a reflected UDP probe
+				p.receiveUDP(&pkt, time.Now())
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if p.errHandler != nil {
 				if now := time.Now(); now.Sub(last) > time.Second {
 					p.errHandler(serrors.WrapStr("straggler packet", err))
 					last = now
 				}
 			}
+			// Don't spin on a broken connection until it is replaced
+			time.Sleep(100 * time.Millisecond)
 		}
 	}
 }
 
+// isSocketError returns true if reading from the connection itself failed, e.g. because the dispatcher
+// was restarted, and not just a packet that couldn't be decoded or handled.
+func isSocketError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
 func packSCMPrequest(local, remote *snet.UDPAddr, req snet.SCMPEchoRequest) (*snet.Packet, error) {
+	return packPacket(local, remote, req)
+}
+
+func packPacket(local, remote *snet.UDPAddr, payload snet.Payload) (*snet.Packet, error) {
 	_, isEmpty := remote.Path.(path.Empty)
 	if isEmpty && !local.IA.Equal(remote.IA) {
 		return nil, serrors.New("no path to remote IA", "local", local.IA, "remote", remote.IA)
@@ -192,7 +422,7 @@ func packSCMPrequest(local, remote *snet.UDPAddr, req snet.SCMPEchoRequest) (*sn
 				Host: addr.HostIP(localHostIP),
 			},
 			Path:    remote.Path,
-			Payload: req,
+			Payload: payload,
 		},
 	}
 	return pkt, nil
@@ -201,6 +431,7 @@ func packSCMPrequest(local, remote *snet.UDPAddr, req snet.SCMPEchoRequest) (*sn
 type reply struct {
 	Received time.Time
 	Source   snet.SCIONAddress
+	Remote   addr.IA // IA the request was sent to, differs from the source for SCMP errors
 	Size     int
 	Reply    snet.SCMPEchoReply
 	Error    error
@@ -212,13 +443,20 @@ type scmpHandler struct {
 }
 
 func (h scmpHandler) Handle(pkt *snet.Packet) error {
-	echo, err := h.handle(pkt)
-	if err != nil {
+	if h.replies == nil {
+		return nil
+	}
+	echo, remote, err := h.handle(pkt)
+	var scmpErr *scmpError
+	if errors.As(err, &scmpErr) {
+		Log.Debug("Received SCMP error from ", pkt.Source, ": ", err)
+	} else if err != nil {
 		Log.Error("Error handling packet ", err)
 	}
 	h.replies <- reply{
 		Received: time.Now().UTC(),
 		Source:   pkt.Source,
+		Remote:   remote,
 		Size:     len(pkt.Bytes),
 		Reply:    echo,
 		Error:    err,
@@ -226,27 +464,156 @@ func (h scmpHandler) Handle(pkt *snet.Packet) error {
 	return nil
 }
 
-func (h scmpHandler) handle(pkt *snet.Packet) (snet.SCMPEchoReply, error) {
+// handle returns the echo reply, or for SCMP errors the echo request quoted in them, and the IA the request was sent to.
+func (h scmpHandler) handle(pkt *snet.Packet) (snet.SCMPEchoReply, addr.IA, error) {
 	if pkt.Payload == nil {
-		return snet.SCMPEchoReply{}, serrors.New("no timing payload found")
+		return snet.SCMPEchoReply{}, 0, serrors.New("no timing payload found")
 	}
 	switch s := pkt.Payload.(type) {
 	case snet.SCMPEchoReply:
 		r := pkt.Payload.(snet.SCMPEchoReply)
 		if r.Identifier != h.id {
-			return snet.SCMPEchoReply{}, serrors.New("wrong SCMP ID",
+			return snet.SCMPEchoReply{}, 0, serrors.New("wrong SCMP ID",
 				"expected", h.id, "actual", r.Identifier)
 		}
-		return r, nil
+		return r, pkt.Source.IA, nil
 	case snet.SCMPExternalInterfaceDown:
-		return snet.SCMPEchoReply{}, serrors.New("external interface down",
-			"isd_as", s.IA, "interface", s.Interface)
-
+		return h.quotedReply(s.Payload, &scmpError{
+			State:     PathDown,
+			TypeCode:  slayers.CreateSCMPTypeCode(s.Type(), s.Code()),
+			IA:        s.IA,
+			Interface: s.Interface,
+		})
+	case snet.SCMPInternalConnectivityDown:
+		return h.quotedReply(s.Payload, &scmpError{
+			State:     InternalConnectivityDown,
+			TypeCode:  slayers.CreateSCMPTypeCode(s.Type(), s.Code()),
+			IA:        s.IA,
+			Interface: s.Egress,
+			Ingress:   s.Ingress,
+		})
+	case snet.SCMPDestinationUnreachable:
+		return h.quotedReply(s.Payload, &scmpError{
+			State:    DestinationUnreachable,
+			TypeCode: slayers.CreateSCMPTypeCode(s.Type(), s.Code()),
+			IA:       pkt.Source.IA,
+		})
+	case snet.SCMPPacketTooBig:
+		return h.quotedReply(s.Payload, &scmpError{
+			State:    PacketTooBig,
+			TypeCode: slayers.CreateSCMPTypeCode(s.Type(), s.Code()),
+			IA:       pkt.Source.IA,
+			MTU:      s.MTU,
+		})
 	case snet.SCMPParameterProblem:
-		return snet.SCMPEchoReply{}, serrors.New("parameter problem",
-			"SCMP Parameter Code", s.Code(), "for handler", h.id)
+		return h.quotedReply(s.Payload, &scmpError{
+			State:    ParameterProblem,
+			TypeCode: slayers.CreateSCMPTypeCode(s.Type(), s.Code()),
+			IA:       pkt.Source.IA,
+		})
+	case snet.SCMPPayload:
+		typeCode := slayers.CreateSCMPTypeCode(s.Type(), s.Code())
+		if typeCode.InfoMsg() {
+			// E.g. a traceroute reply or an echo request of someone else, not an error
+			break
+		}
+		// Any other SCMP error, e.g. one added in a later SCION version
+		return snet.SCMPEchoReply{}, 0, &scmpError{
+			State:    SCMPUnknown,
+			TypeCode: typeCode,
+			IA:       pkt.Source.IA,
+		}
 	default:
 	}
-	return snet.SCMPEchoReply{}, serrors.New("not an SCMPEchoReply",
+	return snet.SCMPEchoReply{}, 0, serrors.New("not an SCMPEchoReply",
 		"type", common.TypeOf(pkt.Payload))
 }
+
+// quotedReply recovers the echo request that triggered an SCMP error from the packet quoted in it,
+// so the error can be passed to the handler of that request instead of letting it time out.
+func (h scmpHandler) quotedReply(quote []byte, scmpErr *scmpError) (snet.SCMPEchoReply, addr.IA, error) {
+	echo, remote, err := parseQuotedEchoRequest(quote)
+	if err != nil {
+		probe, udpRemote, udpErr := parseQuotedUDPProbe(quote)
+		if udpErr != nil {
+			Log.Debug("Failed to parse packet quoted in ", scmpErr, ": ", err)
+			return snet.SCMPEchoReply{}, 0, scmpErr
+		}
+		// Pass it on in the echo payload format, so the pinger handles errors for both probe types alike
+		pld := make([]byte, echoPayloadLen)
+		binary.BigEndian.PutUint64(pld[0:8], unixNanos(probe.Sent))
+		binary.BigEndian.PutUint64(pld[8:16], probe.Sequence)
+		return snet.SCMPEchoReply{
+			Identifier: h.id,
+			SeqNumber:  uint16(probe.Sequence),
+			Payload:    pld,
+		}, udpRemote, scmpErr
+	}
+	if echo.Identifier != h.id {
+		Log.Debug("Wrong SCMP ID in packet quoted in ", scmpErr, ", expected ", h.id, " got ", echo.Identifier)
+		return snet.SCMPEchoReply{}, 0, scmpErr
+	}
+	return snet.SCMPEchoReply{
+		Identifier: echo.Identifier,
+		SeqNumber:  echo.SeqNumber,
+		Payload:    echo.Payload,
+	}, remote, scmpErr
+}
+
+// parseQuotedEchoRequest parses the packet quoted in an SCMP error message, i.e. the offending packet
+// starting with its SCION header, and returns the SCMP echo request contained in it and its destination IA.
+func parseQuotedEchoRequest(quote []byte) (snet.SCMPEchoRequest, addr.IA, error) {
+	var scn slayers.SCION
+	var hbh slayers.HopByHopExtnSkipper
+	var e2e slayers.EndToEndExtnSkipper
+	var scmp slayers.SCMP
+	parser := gopacket.NewDecodingLayerParser(slayers.LayerTypeSCION, &scn, &hbh, &e2e, &scmp)
+	parser.IgnoreUnsupported = true
+	decoded := make([]gopacket.LayerType, 0, 4)
+	if err := parser.DecodeLayers(quote, &decoded); err != nil {
+		return snet.SCMPEchoRequest{}, 0, err
+	}
+	if len(decoded) == 0 || decoded[len(decoded)-1] != slayers.LayerTypeSCMP {
+		return snet.SCMPEchoRequest{}, 0, serrors.New("no SCMP message quoted")
+	}
+	if scmp.TypeCode.Type() != slayers.SCMPTypeEchoRequest {
+		return snet.SCMPEchoRequest{}, 0, serrors.New("quoted SCMP message is not an echo request",
+			"type_code", scmp.TypeCode)
+	}
+
+	var echo slayers.SCMPEcho
+	if err := echo.DecodeFromBytes(scmp.Payload, gopacket.NilDecodeFeedback); err != nil {
+		return snet.SCMPEchoRequest{}, 0, err
+	}
+	return snet.SCMPEchoRequest{
+		Identifier: echo.Identifier,
+		SeqNumber:  echo.SeqNumber,
+		Payload:    echo.Payload,
+	}, scn.DstIA, nil
+}
+
+// scmpError is returned by the scmpHandler for SCMP error messages.
+// It keeps the AS that reported the error and the affected interface(s),
+// so we know where a path broke and not just that it did.
+type scmpError struct {
+	State     State
+	TypeCode  slayers.SCMPTypeCode
+	IA        addr.IA // AS that reported the error
+	Interface uint64  // Interface that is down, the egress interface for internal connectivity down
+	Ingress   uint64  // Ingress interface for internal connectivity down
+	MTU       uint16  // MTU for packet too big
+}
+
+func (e *scmpError) Error() string {
+	switch e.State {
+	case PathDown:
+		return fmt.Sprintf("%s: external interface down at %s#%d", e.TypeCode, e.IA, e.Interface)
+	case InternalConnectivityDown:
+		return fmt.Sprintf("%s: internal connectivity down at %s between #%d and #%d",
+			e.TypeCode, e.IA, e.Ingress, e.Interface)
+	case PacketTooBig:
+		return fmt.Sprintf("%s: packet too big at %s, mtu %d", e.TypeCode, e.IA, e.MTU)
+	default:
+		return fmt.Sprintf("%s reported by %s", e.TypeCode, e.IA)
+	}
+}
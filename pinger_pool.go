@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+const defaultPingerPoolSize = 4
+
+// pingerPool is a small pool of SCION connections that serves all destinations.
+// Each pinger has its own SCMP identifier and demultiplexes the replies of the destinations
+// assigned to it by (source IA, sequence number), see probeKey.
+type pingerPool struct {
+	pingers []*pinger
+}
+
+func newPingerPool(ctx context.Context, size int, localIA addr.IA, localAddr net.UDPAddr) (*pingerPool, error) {
+	pool := &pingerPool{}
+	for i := 0; i < size; i++ {
+		p, err := newPinger(ctx, localIA, localAddr)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.pingers = append(pool.pingers, p)
+	}
+	return pool, nil
+}
+
+// pingerPoolSize returns the configured number of connections, but never more than destinations.
+func pingerPoolSize(destinations int) int {
+	size := defaultPingerPoolSize
+	poolSize := os.Getenv("SCION_PINGER_CONNECTIONS")
+	if poolSize != "" {
+		n, err := strconv.Atoi(poolSize)
+		if err == nil && n > 0 {
+			size = n
+		}
+	}
+	if destinations > 0 && destinations < size {
+		size = destinations
+	}
+	return size
+}
+
+// forDestination returns the pinger serving the destination, each destination always uses the same one.
+func (pp *pingerPool) forDestination(dest string) *pinger {
+	h := fnv.New32a()
+	h.Write([]byte(dest))
+	return pp.pingers[h.Sum32()%uint32(len(pp.pingers))]
+}
+
+// healthy returns an error if the connection of any pinger is broken.
+func (pp *pingerPool) healthy() error {
+	for _, p := range pp.pingers {
+		if err := p.healthy(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pp *pingerPool) Close() {
+	for _, p := range pp.pingers {
+		if err := p.Close(); err != nil {
+			Log.Debug("Failed to close pinger ", p.id, ": ", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/pkg/snet/path"
+)
+
+// testEchoRequestBytes serializes an echo request like the pinger sends it, i.e. what an SCMP error quotes.
+func testEchoRequestBytes(t *testing.T, id, seq uint16) []byte {
+	pkt := &snet.Packet{
+		PacketInfo: snet.PacketInfo{
+			Destination: snet.SCIONAddress{
+				IA:   mustParseIA("1-ff00:0:111"),
+				Host: addr.HostIP(netip.MustParseAddr("10.0.0.2")),
+			},
+			Source: snet.SCIONAddress{
+				IA:   mustParseIA("1-ff00:0:111"),
+				Host: addr.HostIP(netip.MustParseAddr("10.0.0.1")),
+			},
+			Path: path.Empty{},
+			Payload: snet.SCMPEchoRequest{
+				Identifier: id,
+				SeqNumber:  seq,
+				Payload:    make([]byte, 8),
+			},
+		},
+	}
+	if err := pkt.Serialize(); err != nil {
+		t.Fatalf("Failed to serialize echo request: %v", err)
+	}
+	return pkt.Bytes
+}
+
+func TestParseQuotedEchoRequest(t *testing.T) {
+	echo, remote, err := parseQuotedEchoRequest(testEchoRequestBytes(t, 40000, 42))
+	if err != nil {
+		t.Fatalf("Failed to parse quoted echo request: %v", err)
+	}
+	if remote != mustParseIA("1-ff00:0:111") {
+		t.Errorf("Expected quoted destination 1-ff00:0:111, got %s", remote)
+	}
+	if echo.Identifier != 40000 || echo.SeqNumber != 42 {
+		t.Errorf("Expected identifier 40000 and sequence 42, got %d and %d", echo.Identifier, echo.SeqNumber)
+	}
+	if len(echo.Payload) != 8 {
+		t.Errorf("Expected 8 bytes payload, got %d", len(echo.Payload))
+	}
+
+	if _, _, err := parseQuotedEchoRequest([]byte{1, 2, 3}); err == nil {
+		t.Errorf("Expected error for truncated quote")
+	}
+}
+
+func TestSCMPHandler_ErrorRoutedToRequest(t *testing.T) {
+	handler := scmpHandler{id: 40000}
+	pkt := &snet.Packet{
+		PacketInfo: snet.PacketInfo{
+			Source: snet.SCIONAddress{IA: mustParseIA("1-ff00:0:112")},
+			Payload: snet.SCMPExternalInterfaceDown{
+				IA:        mustParseIA("1-ff00:0:112"),
+				Interface: 5,
+				Payload:   testEchoRequestBytes(t, 40000, 42),
+			},
+		},
+	}
+
+	echo, _, err := handler.handle(pkt)
+	scmpErr, ok := err.(*scmpError)
+	if !ok {
+		t.Fatalf("Expected scmpError, got %v", err)
+	}
+	if scmpErr.State != PathDown || scmpErr.Interface != 5 {
+		t.Errorf("Expected PathDown at interface 5, got %v", scmpErr)
+	}
+	if echo.SeqNumber != 42 {
+		t.Errorf("Expected error routed to sequence 42, got %d", echo.SeqNumber)
+	}
+
+	// Errors for requests of other pingers must not be routed to ours
+	handler.id = 40001
+	if echo, _, _ := handler.handle(pkt); echo.SeqNumber != 0 {
+		t.Errorf("Expected error for other identifier not to be routed, got sequence %d", echo.SeqNumber)
+	}
+}
+
+func TestSCMPHandler_InfoMessagesAreNotErrors(t *testing.T) {
+	handler := scmpHandler{id: 40000}
+	pkt := &snet.Packet{
+		PacketInfo: snet.PacketInfo{
+			Source:  snet.SCIONAddress{IA: mustParseIA("1-ff00:0:112")},
+			Payload: snet.SCMPTracerouteReply{Identifier: 40000},
+		},
+	}
+	_, _, err := handler.handle(pkt)
+	if _, ok := err.(*scmpError); ok || err == nil {
+		t.Errorf("Expected traceroute reply not to be an SCMP error, got %v", err)
+	}
+}
+
+func TestPinger_DemultiplexReplies(t *testing.T) {
+	p := &pinger{
+		timeout:        time.Second,
+		updateHandlers: make(map[probeKey]pendingProbe),
+	}
+
+	// Same 16 bit SCMP sequence number for two destinations and after a wraparound
+	received := make(map[probeKey]Update)
+	keys := []probeKey{
+		{remote: mustParseIA("1-ff00:0:111"), sequence: 7},
+		{remote: mustParseIA("1-ff00:0:112"), sequence: 7},
+		{remote: mustParseIA("1-ff00:0:112"), sequence: 7 + 1<<16},
+	}
+	for _, key := range keys {
+		key := key
+		p.updateHandlers[key] = pendingProbe{
+			handler: func(u Update) { received[key] = u },
+			sent:    time.Now(),
+		}
+	}
+
+	for _, key := range keys {
+		pld := make([]byte, echoPayloadLen)
+		binary.BigEndian.PutUint64(pld[0:8], uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(pld[8:16], key.sequence)
+		p.receive(reply{
+			Received: time.Now(),
+			Remote:   key.remote,
+			Reply:    snet.SCMPEchoReply{SeqNumber: uint16(key.sequence), Payload: pld},
+		})
+	}
+
+	for _, key := range keys {
+		u, ok := received[key]
+		if !ok {
+			t.Errorf("Expected reply for %v to be routed to its handler", key)
+			continue
+		}
+		if u.State != Success || u.Sequence != int(key.sequence) {
+			t.Errorf("Expected successful reply with sequence %d, got %v", key.sequence, u)
+		}
+	}
+	if len(p.updateHandlers) != 0 {
+		t.Errorf("Expected all handlers to be removed, %d left", len(p.updateHandlers))
+	}
+}
@@ -11,6 +11,10 @@ type SCIONDestination struct {
 	Address      string `json:"address"`
 	Name         string `json:"name"`
 	ScionVersion string `json:"scion_version"`
+	// Probe types to send, e.g. ["scmp", "udp"], the first one determines the path state. Default: ["scmp"]
+	ProbeTypes []string `json:"probe_types,omitempty"`
+	// SCION UDP port of the responder for UDP probes. Default: 31041
+	UDPPort int `json:"udp_port,omitempty"`
 }
 
 type IPDestination struct {
@@ -21,6 +25,8 @@ type IPDestination struct {
 type Destinations struct {
 	SCIONDestinations []SCIONDestination `json:"scion_destinations"`
 	IPDestinations    []IPDestination    `json:"ip_destinations"`
+	// ID the results are tagged with in mesh mode
+	MeshID string `json:"mesh_id,omitempty"`
 }
 
 func parseRemotesJSON(filename string) (*Destinations, error) {
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Buckets are written once results of this much later arrived, results of a bucket arriving after that
+// are merged into the written row.
+const rollupGrace = 5 * time.Second
+
+// PingRollup aggregates the PingResults of a destination, or of one of its paths, over a minute or an hour.
+// RTTs are in the unit of PingResult.RTT and cover the successful pings only.
+type PingRollup struct {
+	BucketStart  time.Time
+	NodeID       string
+	DstSCIONAddr string
+	ProbeType    string
+	Fingerprint  string // Empty for the rollup of all paths of the destination
+	Count        int
+	Successes    int
+	MinRTT       float64
+	AvgRTT       float64
+	MaxRTT       float64
+	P50RTT       float64
+	P95RTT       float64
+	RTTSum       float64 // To merge late results into the average
+	Fingerprints int     // Distinct fingerprints of the best path
+}
+
+type PingRollupMinute struct {
+	PingRollup `gorm:"embedded"`
+}
+
+func (PingRollupMinute) TableName() string { return "ping_rollups_minute" }
+
+type PingRollupHour struct {
+	PingRollup `gorm:"embedded"`
+}
+
+func (PingRollupHour) TableName() string { return "ping_rollups_hour" }
+
+var rollupTables = []string{"ping_rollups_minute", "ping_rollups_hour"}
+
+// Columns identifying a rollup row
+var rollupKey = []string{"bucket_start", "node_id", "dst_scion_addr", "probe_type", "fingerprint"}
+
+// indexRollups creates the unique index the rollup rows are upserted on.
+func indexRollups(db *gorm.DB) error {
+	for _, table := range rollupTables {
+		err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_" + table + "_key ON " + table +
+			" (bucket_start, node_id, dst_scion_addr, probe_type, fingerprint)").Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rollupBucket struct {
+	resolution   time.Duration
+	start        time.Time
+	nodeID       string
+	dst          string
+	probeType    string
+	fingerprint  string
+	count        int
+	rtts         []float64
+	fingerprints map[string]struct{}
+}
+
+func (b *rollupBucket) rollup() PingRollup {
+	rollup := PingRollup{
+		BucketStart:  b.start,
+		NodeID:       b.nodeID,
+		DstSCIONAddr: b.dst,
+		ProbeType:    b.probeType,
+		Fingerprint:  b.fingerprint,
+		Count:        b.count,
+		Successes:    len(b.rtts),
+		Fingerprints: len(b.fingerprints),
+	}
+	if len(b.rtts) == 0 {
+		return rollup
+	}
+	sort.Float64s(b.rtts)
+	for _, rtt := range b.rtts {
+		rollup.RTTSum += rtt
+	}
+	rollup.MinRTT = b.rtts[0]
+	rollup.MaxRTT = b.rtts[len(b.rtts)-1]
+	rollup.AvgRTT = rollup.RTTSum / float64(len(b.rtts))
+	rollup.P50RTT = percentile(b.rtts, 50)
+	rollup.P95RTT = percentile(b.rtts, 95)
+	return rollup
+}
+
+type rollupBucketKey struct {
+	resolution  time.Duration
+	start       time.Time
+	nodeID      string
+	dst         string
+	probeType   string
+	fingerprint string
+}
+
+// pingRollups accumulates the PingResults of the open buckets in memory, percentiles need all RTTs.
+type pingRollups struct {
+	sync.Mutex
+	buckets map[rollupBucketKey]*rollupBucket
+	latest  time.Time
+}
+
+func newPingRollups() *pingRollups {
+	return &pingRollups{buckets: make(map[rollupBucketKey]*rollupBucket)}
+}
+
+// add adds the result to its minute and hour buckets, of the destination and of the path, and returns
+// the buckets that are complete. The buckets start at full minutes and hours in the location, the time zone
+// of the database rotation, so the rollups are in the database of the period of their results.
+func (r *pingRollups) add(result PingResult, location *time.Location) []*rollupBucket {
+	r.Lock()
+	defer r.Unlock()
+
+	if result.LocalStackDown {
+		// Not pinged
+		return nil
+	}
+	for _, resolution := range []time.Duration{time.Minute, time.Hour} {
+		for _, fingerprint := range []string{"", result.Fingerprint} {
+			key := rollupBucketKey{
+				resolution:  resolution,
+				start:       truncateIn(result.PingTime, resolution, location),
+				nodeID:      result.NodeID,
+				dst:         result.DstSCIONAddr,
+				probeType:   result.ProbeType,
+				fingerprint: fingerprint,
+			}
+			bucket, ok := r.buckets[key]
+			if !ok {
+				bucket = &rollupBucket{
+					resolution:   key.resolution,
+					start:        key.start,
+					nodeID:       key.nodeID,
+					dst:          key.dst,
+					probeType:    key.probeType,
+					fingerprint:  key.fingerprint,
+					fingerprints: make(map[string]struct{}),
+				}
+				r.buckets[key] = bucket
+			}
+			bucket.count++
+			if result.Success {
+				bucket.rtts = append(bucket.rtts, result.RTT)
+				bucket.fingerprints[result.Fingerprint] = struct{}{}
+			}
+			if fingerprint == "" && result.Fingerprint == "" {
+				// Without fingerprint, the path rollup would count the result twice
+				break
+			}
+		}
+	}
+
+	if result.PingTime.After(r.latest) {
+		r.latest = result.PingTime
+	}
+	var complete []*rollupBucket
+	for key, bucket := range r.buckets {
+		if !r.latest.Before(bucket.start.Add(bucket.resolution + rollupGrace)) {
+			complete = append(complete, bucket)
+			delete(r.buckets, key)
+		}
+	}
+	return complete
+}
+
+// truncateIn rounds the time down to a full minute or hour in the location, Truncate rounds in UTC and zones
+// like +05:30 don't start their hours at full hours in UTC. The result is in UTC, as the rollup keys.
+// Truncating with the offset at the time keeps both hours apart that repeat when daylight saving time ends.
+func truncateIn(t time.Time, resolution time.Duration, location *time.Location) time.Time {
+	_, offset := t.In(location).Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.UTC().Add(shift).Truncate(resolution).Add(-shift)
+}
+
+// flush returns all buckets, e.g. before closing.
+func (r *pingRollups) flush() []*rollupBucket {
+	r.Lock()
+	defer r.Unlock()
+	var buckets []*rollupBucket
+	for key, bucket := range r.buckets {
+		buckets = append(buckets, bucket)
+		delete(r.buckets, key)
+	}
+	return buckets
+}
+
+// rebuildRollups replaces the rollups of the hours from..to with the rollups of the ping results in the database.
+func rebuildRollups(tx *gorm.DB, from time.Time, to time.Time, location *time.Location) error {
+	start := truncateIn(from, time.Hour, location)
+	end := truncateIn(to, time.Hour, location).Add(time.Hour)
+	for _, table := range rollupTables {
+		if err := tx.Exec("DELETE FROM "+table+" WHERE bucket_start >= ? AND bucket_start < ?", start, end).Error; err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Model(&PingResult{}).Where("ping_time >= ? AND ping_time < ?", start, end).Order("ping_time").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	// In order of time, every bucket is complete when written
+	rollups := newPingRollups()
+	for rows.Next() {
+		var result PingResult
+		if err := tx.ScanRows(rows, &result); err != nil {
+			return err
+		}
+		if err := upsertRollups(tx, rollups.add(result, location)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return upsertRollups(tx, rollups.flush())
+}
+
+// upsertRollups writes the buckets, rows already written for the same bucket are merged. The merged
+// percentiles are weighted averages of both rows, this only happens for late results or after a restart.
+func upsertRollups(db *gorm.DB, buckets []*rollupBucket) error {
+	merge := clause.OnConflict{
+		Columns: []clause.Column{},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":        gorm.Expr("count + excluded.count"),
+			"successes":    gorm.Expr("successes + excluded.successes"),
+			"rtt_sum":      gorm.Expr("rtt_sum + excluded.rtt_sum"),
+			"fingerprints": gorm.Expr("MAX(fingerprints, excluded.fingerprints)"),
+			"min_rtt":      gorm.Expr(mergeRTT("MIN(min_rtt, excluded.min_rtt)", "min_rtt")),
+			"max_rtt":      gorm.Expr(mergeRTT("MAX(max_rtt, excluded.max_rtt)", "max_rtt")),
+			"avg_rtt":      gorm.Expr(mergeRTT("(rtt_sum + excluded.rtt_sum) / (successes + excluded.successes)", "avg_rtt")),
+			"p50_rtt":      gorm.Expr(mergeRTT(weightedRTT("p50_rtt"), "p50_rtt")),
+			"p95_rtt":      gorm.Expr(mergeRTT(weightedRTT("p95_rtt"), "p95_rtt")),
+		}),
+	}
+	for _, column := range rollupKey {
+		merge.Columns = append(merge.Columns, clause.Column{Name: column})
+	}
+
+	for _, bucket := range buckets {
+		rollup := bucket.rollup()
+		var row interface{} = &PingRollupMinute{rollup}
+		if bucket.resolution == time.Hour {
+			row = &PingRollupHour{rollup}
+		}
+		if err := db.Clauses(merge).Create(row).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeRTT uses the merged value only if both rows have successful pings, otherwise the value of the one that has.
+func mergeRTT(merged string, column string) string {
+	return "CASE WHEN successes = 0 THEN excluded." + column + " WHEN excluded.successes = 0 THEN " + column +
+		" ELSE " + merged + " END"
+}
+
+func weightedRTT(column string) string {
+	return "(" + column + " * successes + excluded." + column + " * excluded.successes) / (successes + excluded.successes)"
+}
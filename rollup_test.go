@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteExporterRollups(t *testing.T) {
+	exporter := NewSQLiteExporter()
+	exporter.rotation, _ = newRotation(rotateNever, time.UTC, "", filepath.Join(t.TempDir(), "pingmetrics.db"))
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	write := func(offset time.Duration, success bool, rtt float64, fingerprint string) {
+		result := PingResult{DstSCIONAddr: "1-ff00:0:111", ProbeType: probeTypeSCMP, PingTime: start.Add(offset),
+			Success: success, RTT: rtt, Fingerprint: fingerprint}
+		if err := exporter.WritePingResult(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		fingerprint := "a"
+		if i%2 == 0 {
+			fingerprint = "b"
+		}
+		write(time.Duration(i)*time.Second, true, float64(i), fingerprint)
+	}
+	write(30*time.Second, false, 1000000, "")
+	// Completes the first minute
+	write(time.Minute+10*time.Second, true, 5, "a")
+
+	var minute PingRollupMinute
+	err := exporter.db.Where("bucket_start = ? AND fingerprint = ''", start).First(&minute).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PingRollup{Count: 21, Successes: 20, MinRTT: 1, MaxRTT: 20, AvgRTT: 10.5, P50RTT: 10, P95RTT: 19,
+		RTTSum: 210, Fingerprints: 2}
+	actual := minute.PingRollup
+	actual.BucketStart, actual.NodeID, actual.DstSCIONAddr, actual.ProbeType = time.Time{}, "", "", ""
+	if actual != expected {
+		t.Errorf("Expected rollup %+v, got %+v", expected, actual)
+	}
+
+	// Late result, merged into the written row
+	write(59*time.Second, true, 0.5, "a")
+	write(2*time.Minute+10*time.Second, true, 5, "a")
+	if err := exporter.db.Where("bucket_start = ? AND fingerprint = ''", start).First(&minute).Error; err != nil {
+		t.Fatal(err)
+	}
+	if minute.Count != 22 || minute.MinRTT != 0.5 || minute.MaxRTT != 20 {
+		t.Errorf("Expected the late result to be merged, got %+v", minute.PingRollup)
+	}
+
+	// Open buckets are written when closing
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := openSQLiteReadOnly(exporter.DbPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDb(db)
+	var hours []PingRollupHour
+	if err := db.Order("fingerprint").Find(&hours).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(hours) != 3 || hours[0].Count != 24 || hours[1].Count != 13 || hours[2].Count != 10 {
+		t.Errorf("Unexpected hourly rollups %+v", hours)
+	}
+}
+
+func TestTruncateIn(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, test := range []struct {
+		t          time.Time
+		resolution time.Duration
+		location   *time.Location
+		expected   time.Time
+	}{
+		{time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), time.Hour, time.UTC, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), time.Minute, kolkata, time.Date(2024, 5, 1, 10, 20, 0, 0, time.UTC)},
+		// 15:50 in Kolkata, its hour started at 09:30 UTC
+		{time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), time.Hour, kolkata, time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)},
+		{time.Date(2024, 5, 1, 18, 29, 0, 0, time.UTC), time.Hour, kolkata, time.Date(2024, 5, 1, 17, 30, 0, 0, time.UTC)},
+		// 02:30 in Zurich twice when daylight saving time ends, in two hours
+		{time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), time.Hour, zurich, time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), time.Hour, zurich, time.Date(2024, 10, 27, 1, 0, 0, 0, time.UTC)},
+	} {
+		if actual := truncateIn(test.t, test.resolution, test.location); !actual.Equal(test.expected) {
+			t.Errorf("Expected %v truncated to %v in %v to be %v, got %v", test.t, test.resolution, test.location,
+				test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	rotateHourly = "hour"
+	rotateDaily  = "day"
+	rotateWeekly = "week"
+	rotateNever  = "none"
+)
+
+// Placeholders in file name templates, replaced by the period the file holds
+var rotationPlaceholder = regexp.MustCompile(`\{(period|date|hour|week)\}`)
+
+// rotation splits results into files by the period of their timestamp, e.g. pingmetrics_{period}.db.
+type rotation struct {
+	period   string
+	location *time.Location
+	template string
+}
+
+// newRotation returns the rotation of the given period, and derives the template from the path if there is none.
+func newRotation(period string, location *time.Location, template string, path string) (rotation, error) {
+	switch period {
+	case rotateHourly, rotateDaily, rotateWeekly, rotateNever:
+	default:
+		return rotation{}, fmt.Errorf("unknown rotation period %q, expected hour, day, week or none", period)
+	}
+	if template == "" {
+		template = path
+		if period != rotateNever {
+			ext := filepath.Ext(path)
+			template = strings.TrimSuffix(path, ext) + "_{period}" + ext
+		}
+	}
+	if period != rotateNever && !rotationPlaceholder.MatchString(template) {
+		return rotation{}, fmt.Errorf("file name template %q has no {period}, {date}, {hour} or {week}", template)
+	}
+	if !pinsPeriod(period, template) {
+		// Otherwise results of different periods would end up in the same file
+		return rotation{}, fmt.Errorf("file name template %q doesn't tell the %s periods apart", template, period)
+	}
+	return rotation{period: period, location: location, template: template}, nil
+}
+
+// pinsPeriod returns whether the placeholders of the template name a single period.
+func pinsPeriod(period string, template string) bool {
+	has := func(placeholder string) bool { return strings.Contains(template, placeholder) }
+	switch period {
+	case rotateHourly:
+		return has("{period}") || has("{date}") && has("{hour}")
+	case rotateDaily:
+		return has("{period}") || has("{date}")
+	case rotateWeekly:
+		// The date of the start of the week
+		return has("{period}") || has("{week}") || has("{date}")
+	}
+	return true
+}
+
+// rotationFromEnv reads <prefix>ROTATION (default day), <prefix>TIMEZONE (default UTC) and <prefix>FILENAME.
+func rotationFromEnv(prefix string, path string) (rotation, error) {
+	period := os.Getenv(prefix + "ROTATION")
+	if period == "" {
+		period = rotateDaily
+	}
+	location := time.UTC
+	if tz := os.Getenv(prefix + "TIMEZONE"); tz != "" {
+		var err error
+		if location, err = time.LoadLocation(tz); err != nil {
+			return rotation{}, fmt.Errorf("invalid %sTIMEZONE %q: %w", prefix, tz, err)
+		}
+	}
+	return newRotation(period, location, os.Getenv(prefix+"FILENAME"), path)
+}
+
+// start returns the start of the period containing t, the zero time if files are not rotated.
+func (r rotation) start(t time.Time) time.Time {
+	t = t.In(r.location)
+	switch r.period {
+	case rotateHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, r.location)
+	case rotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, r.location)
+	case rotateWeekly:
+		// ISO weeks, starting on Monday
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, r.location)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return time.Time{}
+}
+
+// path returns the file holding the results with timestamp t.
+func (r rotation) path(t time.Time) string {
+	start := r.start(t)
+	year, week := start.ISOWeek()
+	return rotationPlaceholder.ReplaceAllStringFunc(r.template, func(placeholder string) string {
+		switch placeholder {
+		case "{period}":
+			switch r.period {
+			case rotateHourly:
+				return start.Format("2006-01-02T15")
+			case rotateWeekly:
+				return fmt.Sprintf("%04d-W%02d", year, week)
+			}
+			return start.Format("2006-01-02")
+		case "{date}":
+			return start.Format("2006-01-02")
+		case "{hour}":
+			return start.Format("15")
+		default:
+			return fmt.Sprintf("%04d-W%02d", year, week)
+		}
+	})
+}
+
+// glob matches the files of all periods.
+func (r rotation) glob() string {
+	return rotationPlaceholder.ReplaceAllString(r.template, "*")
+}
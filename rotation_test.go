@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotationPath(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skip("No time zone database: ", err)
+	}
+	// Sunday evening in UTC, already Monday in Zurich
+	ts := time.Date(2024, 3, 3, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		period   string
+		location *time.Location
+		template string
+		expected string
+	}{
+		{rotateHourly, time.UTC, "", "pingmetrics_2024-03-03T23.db"},
+		{rotateDaily, time.UTC, "", "pingmetrics_2024-03-03.db"},
+		{rotateDaily, zurich, "", "pingmetrics_2024-03-04.db"},
+		{rotateWeekly, time.UTC, "", "pingmetrics_2024-W09.db"},
+		{rotateWeekly, zurich, "", "pingmetrics_2024-W10.db"},
+		{rotateNever, time.UTC, "", "pingmetrics.db"},
+		{rotateHourly, time.UTC, "results/{date}/{hour}.db", "results/2024-03-03/23.db"},
+	}
+	for _, test := range tests {
+		r, err := newRotation(test.period, test.location, test.template, "pingmetrics.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := r.path(ts); path != test.expected {
+			t.Errorf("%s in %s: expected %s, got %s", test.period, test.location, test.expected, path)
+		}
+	}
+
+	if _, err := newRotation(rotateDaily, time.UTC, "pingmetrics.db", ""); err == nil {
+		t.Error("Expected an error for a template without placeholder")
+	}
+	for period, template := range map[string]string{
+		rotateHourly: "{date}/pingmetrics.db",
+		rotateDaily:  "pingmetrics_{hour}.db",
+		rotateWeekly: "pingmetrics_{hour}.db",
+	} {
+		if _, err := newRotation(period, time.UTC, template, ""); err == nil {
+			t.Errorf("Expected an error for %s rotation with template %s", period, template)
+		}
+	}
+}
+
+func TestSQLiteExporterRotatesByTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	exporter := NewSQLiteExporter()
+	exporter.rotation, _ = newRotation(rotateHourly, time.UTC, "", filepath.Join(dir, "pingmetrics.db"))
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	now := time.Now().UTC()
+	next := now.Add(time.Hour)
+	for _, ts := range []time.Time{now, next, now} {
+		if err := exporter.WritePingResult(PingResult{DstSCIONAddr: "1-ff00:0:111", PingTime: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if exporter.DbPath != exporter.rotation.path(next) {
+		t.Errorf("Expected to write to %s, got %s", exporter.rotation.path(next), exporter.DbPath)
+	}
+	for ts, expected := range map[time.Time]int64{now: 2, next: 1} {
+		db := exporter.dbs[exporter.rotation.path(ts)]
+		if db == nil {
+			t.Fatalf("Expected %s to be open", exporter.rotation.path(ts))
+		}
+		var count int64
+		db.Model(&PingResult{}).Count(&count)
+		if count != expected {
+			t.Errorf("Expected %d rows in %s, got %d", expected, exporter.rotation.path(ts), count)
+		}
+	}
+	if _, err := os.Stat(exporter.rotation.path(next)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteExporterCreatesPeriodDirectories(t *testing.T) {
+	dir := t.TempDir()
+	exporter := NewSQLiteExporter()
+	exporter.rotation, _ = newRotation(rotateHourly, time.UTC, filepath.Join(dir, "{date}", "{hour}.db"), "")
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	if _, err := os.Stat(exporter.rotation.path(time.Now())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteExporterWritesOldResultsToCurrentPeriod(t *testing.T) {
+	dir := t.TempDir()
+	exporter := NewSQLiteExporter()
+	exporter.rotation, _ = newRotation(rotateDaily, time.UTC, "", filepath.Join(dir, "pingmetrics.db"))
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1)
+	old := now.AddDate(0, 0, -3)
+	for _, ts := range []time.Time{yesterday, old} {
+		if err := exporter.WritePingResult(PingResult{DstSCIONAddr: "1-ff00:0:111", PingTime: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(exporter.rotation.path(old)); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be reopened", exporter.rotation.path(old))
+	}
+	for ts, expected := range map[time.Time]int64{now: 1, yesterday: 1} {
+		var count int64
+		exporter.dbs[exporter.rotation.path(ts)].Model(&PingResult{}).Count(&count)
+		if count != expected {
+			t.Errorf("Expected %d rows in %s, got %d", expected, exporter.rotation.path(ts), count)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DbMetadata records how a database was produced, as key value pairs.
+type DbMetadata struct {
+	Key   string `gorm:"primaryKey"`
+	Value string
+}
+
+func (DbMetadata) TableName() string { return "metadata" }
+
+const (
+	metadataSchemaVersion = "schema_version"
+	metadataVersions      = "versions" // Versions of multiping that wrote to the database, comma separated
+	metadataNodeID        = "node_id"
+	metadataNodeSite      = "node_site"
+	metadataNodeTags      = "node_tags"
+	metadataRemotesHash   = "remotes_hash"
+)
+
+// Models of the result databases, AutoMigrate adds their new tables and columns when a database is opened
+var resultModels = []interface{}{&PingResult{}, &PathStatistics{}, &IPPingResult{}, &OneWayDelayResult{},
+	&PingRollupMinute{}, &PingRollupHour{}, &DbMetadata{}}
+
+type migration struct {
+	description string
+	migrate     func(tx *gorm.DB) error
+}
+
+// migrations upgrade the databases of older versions in what AutoMigrate can't do. The schema version of a
+// database is the number of migrations applied to it, append new ones at the end.
+var migrations = []migration{
+	{"set the probe type of results before UDP probes", backfillProbeTypes},
+	{"create the unique index of the rollup tables", indexRollups},
+}
+
+var currentSchemaVersion = len(migrations)
+
+// migrateDb brings the database to the current schema, databases of newer versions are refused.
+func migrateDb(db *gorm.DB) (int, error) {
+	version, err := schemaVersion(db, "main")
+	if err != nil {
+		return 0, err
+	}
+	if version > currentSchemaVersion {
+		return version, fmt.Errorf("schema version %d is newer than %d of this version, upgrade multiping",
+			version, currentSchemaVersion)
+	}
+
+	if err := db.AutoMigrate(resultModels...); err != nil {
+		return version, err
+	}
+	for ; version < currentSchemaVersion; version++ {
+		m := migrations[version]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+			return setMetadata(tx, metadataSchemaVersion, strconv.Itoa(version+1))
+		})
+		if err != nil {
+			return version, fmt.Errorf("migration %d to %s: %w", version+1, m.description, err)
+		}
+	}
+	return version, nil
+}
+
+// schemaVersion returns the schema version of the database in the schema, 0 for databases of versions
+// before schema versioning.
+func schemaVersion(db *gorm.DB, schema string) (int, error) {
+	columns, err := tableColumns(db, schema, "metadata")
+	if err != nil || len(columns) == 0 {
+		return 0, err
+	}
+	var value string
+	err = db.Raw(fmt.Sprintf("SELECT value FROM %s.metadata WHERE key = ?", schema), metadataSchemaVersion).Scan(&value).Error
+	if err != nil || value == "" {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+func setMetadata(db *gorm.DB, key string, value string) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&DbMetadata{Key: key, Value: value}).Error
+}
+
+func getMetadata(db *gorm.DB, key string) (string, error) {
+	var metadata DbMetadata
+	err := db.Where("key = ?", key).Take(&metadata).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return metadata.Value, err
+}
+
+// writeMetadata records the version of multiping, node identity and remotes configuration writing to the database.
+func writeMetadata(db *gorm.DB, identity NodeIdentity, remotesHash string) error {
+	versions, err := getMetadata(db, metadataVersions)
+	if err != nil {
+		return err
+	}
+	if !containsString(strings.Split(versions, ","), versionString) {
+		if versions != "" {
+			versions += ","
+		}
+		versions += versionString
+	}
+	for key, value := range map[string]string{
+		metadataVersions:    versions,
+		metadataNodeID:      identity.ID,
+		metadataNodeSite:    identity.Site,
+		metadataNodeTags:    identity.TagString(),
+		metadataRemotesHash: remotesHash,
+	} {
+		if err := setMetadata(db, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remotesHash returns the SHA-256 of the remotes file, empty if there is none.
+func remotesHash(remotesFile string) string {
+	data, err := os.ReadFile(remotesFile)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// backfillProbeTypes sets the probe type of results written before there were other probe types than SCMP.
+func backfillProbeTypes(tx *gorm.DB) error {
+	for _, table := range []string{"ping_results", "path_statistics"} {
+		err := tx.Exec("UPDATE "+table+" SET probe_type = ? WHERE probe_type IS NULL OR probe_type = ''", probeTypeSCMP).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setNodeID sets the node ID of results written before there was a node identity.
+func setNodeID(tx *gorm.DB, node string) error {
+	for _, table := range resultTables {
+		if err := tx.Exec("UPDATE "+table+" SET node_id = ? WHERE node_id IS NULL OR node_id = ''", node).Error; err != nil {
+			return err
+		}
+	}
+	id, err := getMetadata(tx, metadataNodeID)
+	if err != nil || id != "" {
+		return err
+	}
+	return setMetadata(tx, metadataNodeID, node)
+}
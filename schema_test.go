@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMigrateDbFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pingmetrics_2024-05-01.db")
+	testOldDailyDb(t, path, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	from, to, err := migrateDbFile(path, "node-1")
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if from != 0 || to != currentSchemaVersion {
+		t.Errorf("Expected migration from 0 to %d, got %d to %d", currentSchemaVersion, from, to)
+	}
+
+	db, err := openSQLiteReadOnly(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDb(db)
+	var count int64
+	db.Model(&PingResult{}).Where("probe_type = ? AND node_id = ?", probeTypeSCMP, "node-1").Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 rows with probe type and node ID, got %d", count)
+	}
+	if id, _ := getMetadata(db, metadataNodeID); id != "node-1" {
+		t.Errorf("Expected node ID node-1 in the metadata, got %q", id)
+	}
+
+	// Migrating again doesn't change anything
+	if from, to, err := migrateDbFile(path, ""); err != nil || from != to {
+		t.Errorf("Expected no migration, got %d to %d: %v", from, to, err)
+	}
+}
+
+func TestMigrateDbRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pingmetrics.db")
+	db, err := openSQLiteDb(path, durabilityProfiles[durabilityBalanced])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDb(db)
+	setMetadata(db, metadataSchemaVersion, "1000")
+
+	if _, err := migrateDb(db); err == nil {
+		t.Error("Expected databases of newer versions to be refused")
+	}
+}
+
+func TestSQLiteExporterWritesMetadata(t *testing.T) {
+	exporter := NewSQLiteExporter()
+	exporter.rotation, _ = newRotation(rotateNever, time.UTC, "", filepath.Join(t.TempDir(), "pingmetrics.db"))
+	exporter.identity = NodeIdentity{ID: "node-1", Site: "zurich", Tags: map[string]string{"provider": "geant"}}
+	exporter.remotesHash = "abc"
+	if err := exporter.InitDaily(); err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	for key, expected := range map[string]string{
+		metadataSchemaVersion: strconv.Itoa(currentSchemaVersion),
+		metadataNodeID:        "node-1",
+		metadataNodeSite:      "zurich",
+		metadataNodeTags:      "provider=geant",
+		metadataRemotesHash:   "abc",
+	} {
+		if value, err := getMetadata(exporter.db, key); err != nil || value != expected {
+			t.Errorf("Expected %s %q, got %q: %v", key, expected, value, err)
+		}
+	}
+}
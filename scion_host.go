@@ -17,8 +17,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,10 +47,38 @@ const (
 var singletonHostContext hostContext
 var initOnce sync.Once
 
+// Guards singletonHostContext, which is replaced when reconnecting to the local SCION stack
+var hostMutex sync.RWMutex
+
 // host initialises and returns the singleton hostContext.
 func host() *hostContext {
 	initOnce.Do(mustInitHostContext)
-	return &singletonHostContext
+	hostMutex.RLock()
+	defer hostMutex.RUnlock()
+	hc := singletonHostContext
+	return &hc
+}
+
+// setHost replaces the singleton hostContext, e.g. after reconnecting to the local SCION stack.
+func setHost(hc hostContext) {
+	initOnce.Do(func() {})
+	hostMutex.Lock()
+	defer hostMutex.Unlock()
+	singletonHostContext = hc
+}
+
+// connectHostContext connects to the local SCION stack, retrying with exponential backoff until it succeeds.
+func connectHostContext() hostContext {
+	b := newBackoff()
+	for {
+		hc, err := initHostContext()
+		if err == nil {
+			return hc
+		}
+		wait := b.next()
+		Log.Error("Failed to connect to the local SCION stack, retrying in ", wait, ": ", err)
+		time.Sleep(wait)
+	}
 }
 
 func mustInitHostContext() {
@@ -122,17 +153,125 @@ func (h *hostContext) queryPaths(ctx context.Context, dst addr.IA) ([]snet.Path,
 	return snetPaths, nil
 }
 
-// Put here to be replaced in the scion-v12 branch with the dispatcherless connection
-func newSCIONConn(ctx context.Context, handler scmpHandler, localIA addr.IA, localAddr net.UDPAddr) (snet.PacketConn, uint16, error) {
-	dispSockerPath := getDispatcherPath()
-	svc := snet.DefaultPacketDispatcherService{
-		Dispatcher:  reliable.NewDispatcher(dispSockerPath),
-		SCMPHandler: handler,
+// Connection modes to the local SCION stack, selected with SCION_CONNECTION_MODE.
+const (
+	// Use the dispatcher if its socket exists, otherwise connect dispatcher-less
+	connectionModeAuto = "auto"
+	// Register with the dispatcher, SCION v0.11 and earlier
+	connectionModeDispatcher = "dispatcher"
+	// Bind the underlay socket directly, SCION v0.12 and later
+	connectionModeDispatcherless = "dispatcherless"
+)
+
+// Default port range of end hosts in SCION v0.12+, the border routers deliver packets
+// for ports in this range directly, i.e. without a dispatcher (shim)
+const (
+	defaultEndhostPortMin = 31000
+	defaultEndhostPortMax = 32767
+)
+
+func scionConnectionMode() string {
+	mode := os.Getenv("SCION_CONNECTION_MODE")
+	switch mode {
+	case connectionModeDispatcher, connectionModeDispatcherless:
+		return mode
+	case "", connectionModeAuto:
+	default:
+		Log.Warn("Unknown SCION_CONNECTION_MODE ", mode, ", using ", connectionModeAuto)
+	}
+	if getDispatcherPath() != "" {
+		return connectionModeDispatcher
+	}
+	return connectionModeDispatcherless
+}
+
+// newSCIONConn opens a connection to send and receive SCION packets, registered by the packet dispatcher
+// service of the connection mode.
+func newSCIONConn(ctx context.Context, handler *scmpHandler, localIA addr.IA, localAddr net.UDPAddr) (snet.PacketConn, uint16, error) {
+	return packetDispatcherService(scionConnectionMode(), handler).Register(ctx, localIA, &localAddr, addr.SvcNone)
+}
+
+// packetDispatcherService returns the snet service registering connections in the connection mode, the
+// same extension point snet.SCIONNetwork uses: through the dispatcher, or on an underlay socket of our own.
+func packetDispatcherService(mode string, handler *scmpHandler) snet.PacketDispatcherService {
+	if mode == connectionModeDispatcherless {
+		return &underlayDispatcherService{handler: handler}
+	}
+	return &snet.DefaultPacketDispatcherService{
+		Dispatcher:  reliable.NewDispatcher(getDispatcherPath()),
+		SCMPHandler: *handler,
+	}
+}
+
+// underlayDispatcherService binds the underlay UDP socket directly, in the end host port range unless a port
+// is given. The border routers deliver SCMP replies to the port matching the SCMP identifier, so the
+// identifier of the handler is set to the bound port.
+type underlayDispatcherService struct {
+	handler *scmpHandler
+}
+
+func (s *underlayDispatcherService) Register(ctx context.Context, ia addr.IA, registration *net.UDPAddr,
+	svc addr.SVC) (snet.PacketConn, uint16, error) {
+
+	var udpConn *net.UDPConn
+	var err error
+	if registration.Port != 0 {
+		udpConn, err = net.ListenUDP("udp", registration)
+	} else {
+		udpConn, err = listenEndhostPort(*registration)
 	}
-	conn, port, err := svc.Register(ctx, localIA, &localAddr, addr.SvcNone)
 	if err != nil {
 		return nil, 0, err
 	}
+	port := uint16(udpConn.LocalAddr().(*net.UDPAddr).Port)
+	s.handler.id = port
+	return &snet.SCIONPacketConn{
+		Conn:        udpConn,
+		SCMPHandler: *s.handler,
+	}, port, nil
+}
+
+// listenEndhostPort binds the underlay UDP socket to a free port in the end host port range,
+// which can be overridden with SCION_ENDHOST_PORT_RANGE, e.g. "31000-32767".
+func listenEndhostPort(localAddr net.UDPAddr) (*net.UDPConn, error) {
+	portMin, portMax := defaultEndhostPortMin, defaultEndhostPortMax
+	if portRange := os.Getenv("SCION_ENDHOST_PORT_RANGE"); portRange != "" {
+		var err error
+		portMin, portMax, err = parsePortRange(portRange)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return conn, port, nil
+	ports := portMax - portMin + 1
+	offset := rand.Intn(ports)
+	var lastErr error
+	for i := 0; i < ports; i++ {
+		localAddr.Port = portMin + (offset+i)%ports
+		conn, err := net.ListenUDP("udp", &localAddr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("no free port in range %d-%d: %w", portMin, portMax, lastErr)
+}
+
+func parsePortRange(portRange string) (int, int, error) {
+	bounds := strings.SplitN(portRange, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q, expected min-max", portRange)
+	}
+	portMin, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", portRange, err)
+	}
+	portMax, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", portRange, err)
+	}
+	if portMin <= 0 || portMax > 65535 || portMin > portMax {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+	return portMin, portMax, nil
 }
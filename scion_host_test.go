@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/scionproto/scion/pkg/addr"
+)
+
+func TestParsePortRange(t *testing.T) {
+	portMin, portMax, err := parsePortRange(" 31000 - 32767")
+	if err != nil || portMin != 31000 || portMax != 32767 {
+		t.Errorf("Expected 31000-32767, got %d-%d: %v", portMin, portMax, err)
+	}
+	for _, portRange := range []string{"", "31000", "a-b", "31000-", "0-100", "32767-31000", "1-65536", "1-2-3"} {
+		if _, _, err := parsePortRange(portRange); err == nil {
+			t.Errorf("Expected an error for port range %q", portRange)
+		}
+	}
+}
+
+func TestSCIONConnectionMode(t *testing.T) {
+	auto := connectionModeDispatcherless
+	if getDispatcherPath() != "" {
+		auto = connectionModeDispatcher
+	}
+	for mode, expected := range map[string]string{
+		"":                           auto,
+		connectionModeAuto:           auto,
+		connectionModeDispatcher:     connectionModeDispatcher,
+		connectionModeDispatcherless: connectionModeDispatcherless,
+		"dispatcher-less":            auto, // Unknown
+	} {
+		t.Setenv("SCION_CONNECTION_MODE", mode)
+		if actual := scionConnectionMode(); actual != expected {
+			t.Errorf("Mode %q: expected %s, got %s", mode, expected, actual)
+		}
+	}
+}
+
+func TestUnderlayDispatcherService(t *testing.T) {
+	t.Setenv("SCION_ENDHOST_PORT_RANGE", "31000-32767")
+	handler := &scmpHandler{id: 1}
+	service := packetDispatcherService(connectionModeDispatcherless, handler)
+	conn, port, err := service.Register(context.Background(), mustParseIA("1-ff00:0:110"),
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, addr.SvcNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if port < 31000 || port > 32767 {
+		t.Errorf("Expected a port in the end host range, got %d", port)
+	}
+	if handler.id != port {
+		t.Errorf("Expected the SCMP identifier to be the port %d, got %d", port, handler.id)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	durabilityFast     = "fast"     // A power loss may lose the last checkpoint interval
+	durabilityBalanced = "balanced" // A power loss may lose the last transactions, the database stays consistent
+	durabilitySafe     = "safe"     // Every transaction is on disk when it returns
+)
+
+// sqliteTuning are the settings of the databases written by the SQLite exporter, always in WAL mode.
+type sqliteTuning struct {
+	synchronous        string
+	checkpointInterval time.Duration
+	busyTimeout        time.Duration
+}
+
+var durabilityProfiles = map[string]sqliteTuning{
+	durabilityFast:     {synchronous: "OFF", checkpointInterval: 10 * time.Minute, busyTimeout: 5 * time.Second},
+	durabilityBalanced: {synchronous: "NORMAL", checkpointInterval: 1 * time.Minute, busyTimeout: 5 * time.Second},
+	durabilitySafe:     {synchronous: "FULL", checkpointInterval: 10 * time.Second, busyTimeout: 5 * time.Second},
+}
+
+// sqliteTuningFromEnv reads the profile from EXPORTER_SQLITE_DURABILITY (default balanced), its checkpoint
+// interval and busy timeout can be overridden with EXPORTER_SQLITE_CHECKPOINT_INTERVAL and EXPORTER_SQLITE_BUSY_TIMEOUT.
+func sqliteTuningFromEnv() (sqliteTuning, error) {
+	profile := os.Getenv("EXPORTER_SQLITE_DURABILITY")
+	if profile == "" {
+		profile = durabilityBalanced
+	}
+	tuning, ok := durabilityProfiles[profile]
+	if !ok {
+		return sqliteTuning{}, fmt.Errorf("unknown EXPORTER_SQLITE_DURABILITY %q, expected fast, balanced or safe", profile)
+	}
+	if interval := os.Getenv("EXPORTER_SQLITE_CHECKPOINT_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return sqliteTuning{}, fmt.Errorf("invalid EXPORTER_SQLITE_CHECKPOINT_INTERVAL %q", interval)
+		}
+		tuning.checkpointInterval = d
+	}
+	if timeout := os.Getenv("EXPORTER_SQLITE_BUSY_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			return sqliteTuning{}, fmt.Errorf("invalid EXPORTER_SQLITE_BUSY_TIMEOUT %q", timeout)
+		}
+		tuning.busyTimeout = d
+	}
+	return tuning, nil
+}
+
+// dsn sets the pragmas in the connection string, so they apply to every connection of the pool.
+func (t sqliteTuning) dsn(path string) string {
+	return path + "?_journal_mode=WAL&_synchronous=" + t.synchronous +
+		"&_busy_timeout=" + strconv.FormatInt(t.busyTimeout.Milliseconds(), 10)
+}
+
+// openSQLiteReadOnly opens a database read-only, e.g. the live database while the exporter writes to it.
+// In WAL mode readers don't block the writer.
+func openSQLiteReadOnly(path string, busyTimeout time.Duration) (*gorm.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	dsn := readOnlyURI(path) + "&_query_only=true&_busy_timeout=" + strconv.FormatInt(busyTimeout.Milliseconds(), 10)
+	return gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+}
+
+// readOnlyURI returns the URI of the database opened read-only, e.g. file:pingmetrics_2024-05-01.db?mode=ro
+func readOnlyURI(path string) string {
+	return "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path) + "?mode=ro"
+}
+
+const (
+	// Moves as much of the WAL into the database file as the readers allow, without waiting for them
+	checkpointPassive = "PASSIVE"
+	// Moves the whole WAL and truncates it, waiting for readers up to the busy timeout
+	checkpointTruncate = "TRUNCATE"
+)
+
+func checkpoint(db *gorm.DB, mode string) error {
+	return db.Exec("PRAGMA wal_checkpoint(" + mode + ")").Error
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// exporterStatus is the state of an exporter shown by the status API.
+type exporterStatus struct {
+	Exporter string `json:"exporter"`
+	Pending  int    `json:"pending"`           // Results or lines buffered in memory
+	Spooled  int    `json:"spooled,omitempty"` // Batches spooled to disk
+	File     string `json:"file,omitempty"`    // Database or file of the current period
+}
+
+// statusReporter is implemented by the exporters with buffered results or a current file.
+type statusReporter interface {
+	status() []exporterStatus
+}
+
+func (m multiExporter) status() []exporterStatus {
+	var statuses []exporterStatus
+	for _, exporter := range m {
+		if reporter, ok := exporter.(statusReporter); ok {
+			statuses = append(statuses, reporter.status()...)
+		}
+	}
+	return statuses
+}
+
+func (e *identityExporter) status() []exporterStatus {
+	if reporter, ok := e.DataExporter.(statusReporter); ok {
+		return reporter.status()
+	}
+	return nil
+}
+
+type nodeStatus struct {
+	Version             string            `json:"version"`
+	NodeID              string            `json:"node_id"`
+	Site                string            `json:"site,omitempty"`
+	Tags                map[string]string `json:"tags,omitempty"`
+	MeshID              string            `json:"mesh_id,omitempty"`
+	Started             time.Time         `json:"started"`
+	Uptime              string            `json:"uptime"`
+	LocalStackAvailable bool              `json:"local_stack_available"`
+	Pingers             []pingerStatus    `json:"pingers"`
+	Exporters           []exporterStatus  `json:"exporters"`
+}
+
+type pingerStatus struct {
+	ID       uint16  `json:"id"`
+	Local    string  `json:"local"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	AvgRTT   float64 `json:"avg_rtt"`
+}
+
+type destinationStatus struct {
+	Address    string       `json:"address"`
+	Name       string       `json:"name,omitempty"`
+	ProbeTypes []string     `json:"probe_types"`
+	Selected   []string     `json:"selected"` // Fingerprints of the paths that are pinged, see pingPathSets
+	Paths      []pathStatus `json:"paths"`
+}
+
+type pathStatus struct {
+	Fingerprint    string `json:"fingerprint"`
+	Path           string `json:"path"`
+	Hops           int    `json:"hops"`
+	State          string `json:"state"`
+	RTT            int64  `json:"rtt"` // ms, of the last successful probe
+	Selected       bool   `json:"selected"`
+	ErrorIA        string `json:"error_ia,omitempty"`
+	ErrorInterface uint64 `json:"error_interface,omitempty"`
+}
+
+// statusServer answers what a running instance is doing: GET /status for the node, pingers and exporters,
+// GET /destinations for the paths of all destinations and GET /destinations/{address} for one of them.
+type statusServer struct {
+	prober   *PathProber
+	identity NodeIdentity
+	names    map[string]string // Destination names by address
+	started  time.Time
+}
+
+func newStatusServer(prober *PathProber, identity NodeIdentity, names map[string]string) *statusServer {
+	return &statusServer{prober: prober, identity: identity, names: names, started: time.Now()}
+}
+
+func (s *statusServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.nodeStatus())
+	})
+	mux.HandleFunc("GET /destinations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.destinations())
+	})
+	mux.HandleFunc("GET /destinations/{address}", func(w http.ResponseWriter, r *http.Request) {
+		dest, ok := s.prober.destinations[r.PathValue("address")]
+		if !ok {
+			http.Error(w, "unknown destination", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, s.destination(r.PathValue("address"), dest))
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		Log.Debug("Failed to write status: ", err)
+	}
+}
+
+func (s *statusServer) nodeStatus() nodeStatus {
+	status := nodeStatus{
+		Version:             versionString,
+		NodeID:              s.identity.ID,
+		Site:                s.identity.Site,
+		Tags:                s.identity.Tags,
+		MeshID:              s.prober.MeshID,
+		Started:             s.started.UTC(),
+		Uptime:              time.Since(s.started).Round(time.Second).String(),
+		LocalStackAvailable: s.prober.LocalStackAvailable(),
+		Pingers:             []pingerStatus{},
+		Exporters:           []exporterStatus{},
+	}
+	if pool := s.prober.getPingerPool(); pool != nil {
+		for _, p := range pool.pingers {
+			stats := p.Stats()
+			status.Pingers = append(status.Pingers, pingerStatus{
+				ID:       p.id,
+				Local:    p.local.String(),
+				Sent:     stats.Sent,
+				Received: stats.Received,
+				AvgRTT:   stats.AvgRTT,
+			})
+		}
+	}
+	if reporter, ok := s.prober.Exporter.(statusReporter); ok {
+		status.Exporters = append(status.Exporters, reporter.status()...)
+	}
+	return status
+}
+
+func (s *statusServer) destinations() []destinationStatus {
+	addresses := make([]string, 0, len(s.prober.destinations))
+	for address := range s.prober.destinations {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	destinations := make([]destinationStatus, 0, len(addresses))
+	for _, address := range addresses {
+		destinations = append(destinations, s.destination(address, s.prober.destinations[address]))
+	}
+	return destinations
+}
+
+func (s *statusServer) destination(address string, dest *PingDestination) destinationStatus {
+	status := destinationStatus{
+		Address:    address,
+		Name:       s.names[address],
+		ProbeTypes: dest.probeTypes(),
+		Selected:   []string{},
+		Paths:      []pathStatus{},
+	}
+	pingPathSets.Lock()
+	for _, path := range pingPathSets.Paths[address] {
+		status.Selected = append(status.Selected, calculateFingerprint(path))
+	}
+	pingPathSets.Unlock()
+
+	dest.Lock()
+	defer dest.Unlock()
+	for _, path := range dest.PathStates {
+		p := pathStatus{
+			Fingerprint: path.Fingerprint,
+			State:       pathStateName(path.State),
+			RTT:         path.RTT,
+			Selected:    containsString(status.Selected, path.Fingerprint),
+		}
+		if path.Path != nil {
+			p.Path = pathInterfacesString(path.Path)
+			p.Hops = len(path.Path.Metadata().Interfaces)
+		}
+		if !path.ErrorIA.IsZero() {
+			p.ErrorIA = path.ErrorIA.String()
+			p.ErrorInterface = path.ErrorInterface
+		}
+		status.Paths = append(status.Paths, p)
+	}
+	return status
+}
+
+// serveStatusFromEnv serves the status API on STATUS_LISTEN_ADDRESS, e.g. localhost:8080, if it is set.
+func serveStatusFromEnv(prober *PathProber, identity NodeIdentity, names map[string]string) {
+	listenAddress := os.Getenv("STATUS_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return
+	}
+	server := &http.Server{
+		Addr:              listenAddress,
+		Handler:           newStatusServer(prober, identity, names).handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	Log.Info("Serving status on ", listenAddress)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			Log.Error("Status server failed: ", err)
+		}
+	}()
+}
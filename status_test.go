@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+func TestStatusServer(t *testing.T) {
+	prober := NewPathProber(10, 3)
+	remote, err := snet.ParseUDPAddr("1-ff00:0:111,10.0.0.2:30041")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prober.SetDestinations([]snet.UDPAddr{*remote})
+	dest := prober.destinations[remote.String()]
+	selected := testPathStatus(PATH_STATE_PING, "aa", "1-ff00:0:110", "1-ff00:0:111")
+	selected.RTT = 12
+	down := testPathStatus(PATH_STATE_DOWN, "bb", "1-ff00:0:110", "1-ff00:0:120", "1-ff00:0:111")
+	selected.Fingerprint = calculateFingerprint(selected.Path)
+	down.Fingerprint = calculateFingerprint(down.Path)
+	dest.PathStates = []PathStatus{selected, down}
+
+	pingPathSets.Lock()
+	pingPathSets.Paths = map[string][]snet.Path{remote.String(): {selected.Path}}
+	pingPathSets.Unlock()
+	defer func() {
+		pingPathSets.Lock()
+		pingPathSets.Paths = nil
+		pingPathSets.Unlock()
+	}()
+
+	identity := NodeIdentity{ID: "node-1"}
+	prober.Exporter = newIdentityExporter(multiExporter{&InfluxExporter{pending: []string{"a", "b"}}}, identity)
+	server := httptest.NewServer(newStatusServer(prober, identity, map[string]string{remote.String(): "ETH"}).handler())
+	defer server.Close()
+
+	var status nodeStatus
+	getJSON(t, server.URL+"/status", &status)
+	if status.NodeID != "node-1" || len(status.Exporters) != 1 || status.Exporters[0].Pending != 2 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	var destination destinationStatus
+	getJSON(t, server.URL+"/destinations/"+url.PathEscape(remote.String()), &destination)
+	if destination.Name != "ETH" || len(destination.Paths) != 2 {
+		t.Fatalf("Unexpected destination %+v", destination)
+	}
+	if destination.Paths[0].State != "PATH_STATE_PING" || destination.Paths[0].RTT != 12 || !destination.Paths[0].Selected {
+		t.Errorf("Unexpected path %+v", destination.Paths[0])
+	}
+	if destination.Paths[1].State != "PATH_STATE_DOWN" || destination.Paths[1].Selected || destination.Paths[1].Path != "1-ff00:0:110#1->1-ff00:0:120#2->1-ff00:0:111#3" {
+		t.Errorf("Unexpected path %+v", destination.Paths[1])
+	}
+
+	response, err := http.Get(server.URL + "/destinations/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown destination, got %d", response.StatusCode)
+	}
+}
+
+func getJSON(t *testing.T, url string, value interface{}) {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		t.Fatal(err)
+	}
+}
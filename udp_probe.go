@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/private/serrors"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// Probe types, selectable per destination
+const (
+	probeTypeSCMP = "scmp" // SCMP echo request/reply
+	probeTypeUDP  = "udp"  // Timestamped UDP datagram reflected by a responder on the remote
+)
+
+// Default SCION UDP port of the responder, inside the default end host port range of SCION v0.12+
+const defaultResponderPort = 31041
+
+// UDP probe packet layout, all integers big endian:
+//
+//	magic "MPNG" (4) | version (1) | flags (1) | reserved (2) | sequence (8) |
+//	sent (8) | remote received (8) | remote sent (8)
+//
+// Timestamps are unix nanoseconds, the remote ones are filled in by the responder (0 if not).
+const (
+	udpProbeVersion = 1
+	udpProbeLen     = 48
+)
+
+var udpProbeMagic = []byte("MPNG")
+
+type udpProbe struct {
+	Sequence       uint64
+	Sent           time.Time
+	RemoteReceived time.Time
+	RemoteSent     time.Time
+}
+
+func (u udpProbe) marshal() []byte {
+	b := make([]byte, udpProbeLen)
+	copy(b[0:4], udpProbeMagic)
+	b[4] = udpProbeVersion
+	binary.BigEndian.PutUint64(b[8:16], u.Sequence)
+	binary.BigEndian.PutUint64(b[16:24], unixNanos(u.Sent))
+	binary.BigEndian.PutUint64(b[24:32], unixNanos(u.RemoteReceived))
+	binary.BigEndian.PutUint64(b[32:40], unixNanos(u.RemoteSent))
+	return b
+}
+
+func parseUDPProbe(b []byte) (udpProbe, error) {
+	if len(b) < udpProbeLen || !bytes.Equal(b[0:4], udpProbeMagic) {
+		return udpProbe{}, serrors.New("not a UDP probe", "len", len(b))
+	}
+	if b[4] != udpProbeVersion {
+		return udpProbe{}, serrors.New("unsupported UDP probe version", "version", b[4])
+	}
+	return udpProbe{
+		Sequence:       binary.BigEndian.Uint64(b[8:16]),
+		Sent:           fromUnixNanos(binary.BigEndian.Uint64(b[16:24])),
+		RemoteReceived: fromUnixNanos(binary.BigEndian.Uint64(b[24:32])),
+		RemoteSent:     fromUnixNanos(binary.BigEndian.Uint64(b[32:40])),
+	}, nil
+}
+
+func unixNanos(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func fromUnixNanos(ns uint64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ns))
+}
+
+func packUDPProbe(local, remote *snet.UDPAddr, pld []byte) (*snet.Packet, error) {
+	return packPacket(local, remote, snet.UDPPayload{
+		SrcPort: uint16(local.Host.Port),
+		DstPort: uint16(remote.Host.Port),
+		Payload: pld,
+	})
+}
+
+// parseQuotedUDPProbe parses the packet quoted in an SCMP error message and returns the UDP probe
+// contained in it and its destination IA.
+func parseQuotedUDPProbe(quote []byte) (udpProbe, addr.IA, error) {
+	var scn slayers.SCION
+	var hbh slayers.HopByHopExtnSkipper
+	var e2e slayers.EndToEndExtnSkipper
+	var udp slayers.UDP
+	parser := gopacket.NewDecodingLayerParser(slayers.LayerTypeSCION, &scn, &hbh, &e2e, &udp)
+	parser.IgnoreUnsupported = true
+	decoded := make([]gopacket.LayerType, 0, 4)
+	if err := parser.DecodeLayers(quote, &decoded); err != nil {
+		return udpProbe{}, 0, err
+	}
+	if len(decoded) == 0 || decoded[len(decoded)-1] != slayers.LayerTypeSCIONUDP {
+		return udpProbe{}, 0, serrors.New("no UDP datagram quoted")
+	}
+	probe, err := parseUDPProbe(udp.Payload)
+	if err != nil {
+		return udpProbe{}, 0, err
+	}
+	return probe, scn.DstIA, nil
+}
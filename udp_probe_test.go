@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/scionproto/scion/pkg/snet/path"
+)
+
+func TestUDPProbe_MarshalParse(t *testing.T) {
+	probe := udpProbe{
+		Sequence:       1<<32 + 7,
+		Sent:           time.Unix(0, 1700000000123456789),
+		RemoteReceived: time.Unix(0, 1700000000223456789),
+	}
+	parsed, err := parseUDPProbe(probe.marshal())
+	if err != nil {
+		t.Fatalf("Failed to parse UDP probe: %v", err)
+	}
+	if parsed.Sequence != probe.Sequence || !parsed.Sent.Equal(probe.Sent) || !parsed.RemoteReceived.Equal(probe.RemoteReceived) {
+		t.Errorf("Expected %v, got %v", probe, parsed)
+	}
+	if !parsed.RemoteSent.IsZero() {
+		t.Errorf("Expected unset remote sent timestamp, got %v", parsed.RemoteSent)
+	}
+
+	if _, err := parseUDPProbe([]byte("hello world")); err == nil {
+		t.Errorf("Expected error for non-probe payload")
+	}
+}
+
+func TestParseQuotedUDPProbe(t *testing.T) {
+	local := &snet.UDPAddr{IA: mustParseIA("1-ff00:0:110"), Host: &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 31000}}
+	remote := &snet.UDPAddr{IA: mustParseIA("1-ff00:0:110"), Host: &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: defaultResponderPort}, Path: path.Empty{}}
+	pkt, err := packUDPProbe(local, remote, udpProbe{Sequence: 42, Sent: time.Now()}.marshal())
+	if err != nil {
+		t.Fatalf("Failed to pack UDP probe: %v", err)
+	}
+	if err := pkt.Serialize(); err != nil {
+		t.Fatalf("Failed to serialize UDP probe: %v", err)
+	}
+
+	probe, dst, err := parseQuotedUDPProbe(pkt.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse quoted UDP probe: %v", err)
+	}
+	if probe.Sequence != 42 || dst != remote.IA {
+		t.Errorf("Expected sequence 42 to %s, got %d to %s", remote.IA, probe.Sequence, dst)
+	}
+}
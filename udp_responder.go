@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/private/common"
+	"github.com/scionproto/scion/pkg/private/serrors"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+const (
+	defaultResponderRateLimit = 10 // Probes per second and source
+	defaultResponderBurst     = 20
+	responderStatsInterval    = 1 * time.Minute
+)
+
+type responderConfig struct {
+	Port      int
+	RateLimit float64 // Probes per second and source, 0 disables rate limiting
+	Burst     int
+}
+
+// responderConfigFromEnv reads RESPONDER_PORT, RESPONDER_RATE_LIMIT and RESPONDER_BURST.
+func responderConfigFromEnv() (responderConfig, error) {
+	config := responderConfig{
+		Port:      defaultResponderPort,
+		RateLimit: defaultResponderRateLimit,
+		Burst:     defaultResponderBurst,
+	}
+	if port := os.Getenv("RESPONDER_PORT"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return config, fmt.Errorf("invalid RESPONDER_PORT %q", port)
+		}
+		config.Port = p
+	}
+	if rateLimit := os.Getenv("RESPONDER_RATE_LIMIT"); rateLimit != "" {
+		r, err := strconv.ParseFloat(rateLimit, 64)
+		if err != nil || r < 0 {
+			return config, fmt.Errorf("invalid RESPONDER_RATE_LIMIT %q", rateLimit)
+		}
+		config.RateLimit = r
+	}
+	if burst := os.Getenv("RESPONDER_BURST"); burst != "" {
+		b, err := strconv.Atoi(burst)
+		if err != nil || b <= 0 {
+			return config, fmt.Errorf("invalid RESPONDER_BURST %q", burst)
+		}
+		config.Burst = b
+	}
+	return config, nil
+}
+
+// runResponderMode only reflects the probes of other multiping instances, without probing itself.
+func runResponderMode() {
+	config, err := responderConfigFromEnv()
+	if err != nil {
+		Log.Error("Invalid responder configuration: ", err)
+		os.Exit(1)
+	}
+
+	hc := connectHostContext()
+	setHost(hc)
+	Log.Info("Connecting to the local SCION stack in ", scionConnectionMode(), " mode")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	responder, err := newUDPResponder(ctx, hc.ia, net.UDPAddr{IP: getSaddr(hc.hostInLocalAS), Port: config.Port}, config)
+	if err != nil {
+		Log.Error("Error starting UDP responder: ", err)
+		os.Exit(1)
+	}
+	Log.Info("Responding on ", responder.local, " with a rate limit of ", config.RateLimit, " probes/s per source")
+
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signalChannel
+		fmt.Printf("Received signal: %s\n", sig)
+		cancel()
+	}()
+
+	responder.run(ctx)
+	fmt.Println("Exiting...")
+}
+
+// udpResponder reflects UDP probes back to their sender over the reversed path,
+// adding the receive and send timestamps.
+type udpResponder struct {
+	conn      snet.PacketConn
+	local     *snet.UDPAddr
+	limiter   *rateLimiter
+	reflected atomic.Uint64
+	limited   atomic.Uint64
+}
+
+func newUDPResponder(ctx context.Context, localIA addr.IA, localAddr net.UDPAddr, config responderConfig) (*udpResponder, error) {
+	// SCMP messages to the responder are not ours to handle, the handler drops them
+	handler := scmpHandler{id: snet.RandomSCMPIdentifer()}
+	conn, port, err := newSCIONConn(ctx, &handler, localIA, localAddr)
+	if err != nil {
+		return nil, err
+	}
+	localAddr.Port = int(port)
+	return &udpResponder{
+		conn:    conn,
+		local:   &snet.UDPAddr{IA: localIA, Host: &localAddr},
+		limiter: newRateLimiter(config.RateLimit, config.Burst),
+	}, nil
+}
+
+// run reflects probes until the context is done.
+func (r *udpResponder) run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(responderStatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				r.conn.Close()
+				return
+			case <-ticker.C:
+				r.limiter.expire(responderStatsInterval)
+				Log.Info("Responder reflected ", r.reflected.Load(), " probes, rate limited ", r.limited.Load())
+			}
+		}
+	}()
+
+	for {
+		var pkt snet.Packet
+		var ov net.UDPAddr
+		if err := r.conn.ReadFrom(&pkt, &ov); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			Log.Debug("Responder failed to read packet: ", err)
+			// Don't spin on a broken connection
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		received := time.Now()
+		if !r.limiter.allow(pkt.Source.String(), received) {
+			r.limited.Add(1)
+			continue
+		}
+		if err := r.reflect(&pkt, &ov, received); err != nil {
+			Log.Debug("Responder dropped packet from ", pkt.Source, ": ", err)
+			continue
+		}
+		r.reflected.Add(1)
+	}
+}
+
+func (r *udpResponder) reflect(pkt *snet.Packet, ov *net.UDPAddr, received time.Time) error {
+	udp, ok := pkt.Payload.(snet.UDPPayload)
+	if !ok {
+		return serrors.New("not a UDP packet")
+	}
+	probe, err := parseUDPProbe(udp.Payload)
+	if err != nil {
+		return err
+	}
+	rpath, ok := pkt.Path.(snet.RawPath)
+	if !ok {
+		return serrors.New("unexpected path", "type", common.TypeOf(pkt.Path))
+	}
+	replyPath, err := snet.DefaultReplyPather{}.ReplyPath(rpath)
+	if err != nil {
+		return err
+	}
+
+	pkt.Destination, pkt.Source = pkt.Source, pkt.Destination
+	pkt.Path = replyPath
+	probe.RemoteReceived = received
+	probe.RemoteSent = time.Now()
+	pkt.Payload = snet.UDPPayload{
+		SrcPort: udp.DstPort,
+		DstPort: udp.SrcPort,
+		Payload: probe.marshal(),
+	}
+	return r.conn.WriteTo(pkt, ov)
+}
+
+// rateLimiter is a token bucket per source.
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow returns true if the source has a token left and takes it.
+func (l *rateLimiter) allow(source string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	bucket, ok := l.buckets[source]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[source] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// expire forgets sources we haven't heard from for maxAge, their buckets would be full anyway.
+func (l *rateLimiter) expire(maxAge time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	for source, bucket := range l.buckets {
+		if time.Since(bucket.last) > maxAge {
+			delete(l.buckets, source)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_PerSource(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if !l.allow("1-ff00:0:110,10.0.0.1", now) {
+			t.Fatalf("Expected probe %d within burst to be allowed", i)
+		}
+	}
+	if l.allow("1-ff00:0:110,10.0.0.1", now) {
+		t.Errorf("Expected probe exceeding burst to be limited")
+	}
+	if !l.allow("1-ff00:0:111,10.0.0.1", now) {
+		t.Errorf("Expected other source not to be limited")
+	}
+	if !l.allow("1-ff00:0:110,10.0.0.1", now.Add(time.Second)) {
+		t.Errorf("Expected probe to be allowed after refill")
+	}
+
+	unlimited := newRateLimiter(0, 1)
+	for i := 0; i < 10; i++ {
+		if !unlimited.allow("1-ff00:0:110,10.0.0.1", now) {
+			t.Fatalf("Expected no rate limiting with rate 0")
+		}
+	}
+}